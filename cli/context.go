@@ -0,0 +1,41 @@
+// Package cli holds the command line plumbing shared by the OwlCode tools.
+package cli
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// SignalContext returns a context that is cancelled on the first Ctrl-C (SIGINT) or once
+// deadline has passed, when deadline is above zero. A second Ctrl-C kills the process as usual.
+func SignalContext(deadline time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	cancel := stop
+	if deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, deadline)
+		cancel = func() {
+			cancelDeadline()
+			stop()
+		}
+	}
+	// Hand SIGINT back to the default handler once cancelled, so a second Ctrl-C exits at once
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, cancel
+}
+
+// Interrupted explains why ctx was cancelled, for the partial report footer
+func Interrupted(ctx context.Context) string {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return "Deadline reached"
+	case context.Canceled:
+		return "Interrupted"
+	}
+	return ""
+}
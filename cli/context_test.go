@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignalContextDeadline(t *testing.T) {
+	ctx, cancel := SignalContext(10 * time.Millisecond)
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Deadline did not cancel the context")
+	}
+	if got := Interrupted(ctx); got != "Deadline reached" {
+		t.Errorf("Did not explain the deadline, got: %q", got)
+	}
+}
+
+func TestSignalContextCancel(t *testing.T) {
+	ctx, cancel := SignalContext(0)
+	if Interrupted(ctx) != "" {
+		t.Errorf("Running context reported as interrupted")
+	}
+	cancel()
+	if got := Interrupted(ctx); got != "Interrupted" {
+		t.Errorf("Did not explain the cancel, got: %q", got)
+	}
+}
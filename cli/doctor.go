@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/config"
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Doctor runs the "doctor" command: it prints the settings in effect for reaching the API (config files,
+// profile, endpoint, proxy, CA bundle, client certificate and minimum TLS version) and then sends one small
+// query to check that the endpoint can be reached with them
+func (c *Common) Doctor(ctx context.Context, w io.Writer) error {
+	// A missing token is reported below rather than stopping the check
+	conf, err := config.Load(config.Options{Path: c.ConfigPath, Profile: c.Profile, NoToken: true})
+	if err != nil {
+		return err
+	}
+	n := conf.Network
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	line := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(tw, "%s:\t%s\n", name, fmt.Sprintf(format, args...))
+	}
+
+	line("Config", "%s", orNone(strings.Join(conf.Sources, ", "), "defaults only"))
+	line("Profile", "%s", orNone(conf.Profile, "none"))
+	line("Endpoint", "%s", conf.Endpoint)
+	if conf.Token == "" {
+		line("Token", "missing, set it in a config file or with %s", config.Env.Token)
+	} else {
+		line("Token", "set")
+	}
+
+	proxy, err := n.ProxyFor(conf.Endpoint)
+	switch {
+	case err != nil:
+		line("Proxy", "invalid: %s", err)
+	case proxy == nil:
+		line("Proxy", "none, connecting directly")
+	case n.Proxy != "":
+		line("Proxy", "%s (network.proxy)", proxy.Redacted())
+	default:
+		line("Proxy", "%s (environment)", proxy.Redacted())
+	}
+
+	if n.CAFile == "" {
+		line("CA bundle", "system roots only")
+	} else if b, err := os.ReadFile(n.CAFile); err != nil {
+		line("CA bundle", "unreadable: %s", err)
+	} else {
+		line("CA bundle", "%s (%d certificates) on top of the system roots", n.CAFile, countCertificates(b))
+	}
+
+	cert, err := n.ClientCertificate()
+	switch {
+	case err != nil:
+		line("Client cert", "invalid: %s", err)
+	case cert == nil:
+		line("Client cert", "none")
+	case cert.Leaf != nil:
+		line("Client cert", "%s (%s), expires %s", n.CertFile, cert.Leaf.Subject, cert.Leaf.NotAfter.Format(time.DateOnly))
+	default:
+		line("Client cert", "%s", n.CertFile)
+	}
+	line("Min TLS", "%s", n.MinTLSVersion)
+
+	if c.Replay != "" {
+		line("Connection", "not checked, replaying %s", c.Replay)
+		return tw.Flush()
+	}
+	result, checkErr := checkConnection(ctx, conf)
+	if checkErr != nil {
+		line("Connection", "failed: %s", checkErr)
+	} else {
+		line("Connection", "%s", result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if checkErr != nil {
+		return fmt.Errorf("could not reach %s", conf.Endpoint)
+	}
+	return nil
+}
+
+// Sends a { __typename } query through a graphQL client built like the tools' own, with the configured
+// transport, token header and timeout, and describes the connection it got
+func checkConnection(ctx context.Context, conf config.Config) (string, error) {
+	transport, err := graphql.NewTransport(conf.Network)
+	if err != nil {
+		return "", err
+	}
+	defer transport.CloseIdleConnections()
+	opts := conf.ClientOptions()
+	opts.Transport = transport
+	// One attempt, so a failing check is reported at once rather than after the backoff
+	opts.Retry = graphql.RetryPolicy{MaxAttempts: 1}
+	client := graphql.NewClient(opts)
+
+	var state *tls.ConnectionState
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err == nil {
+				state = &cs
+			}
+		},
+	})
+	start := time.Now()
+	var out map[string]interface{}
+	err = client.Do(ctx, graphql.Request{Query: "{ __typename }"}, &out)
+
+	status := http.StatusOK
+	var statusErr *graphql.StatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.StatusCode
+	} else if err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("HTTP %d in %s", status, time.Since(start).Round(time.Millisecond))
+	if state != nil {
+		result += ", " + tls.VersionName(state.Version)
+		if certs := state.PeerCertificates; len(certs) > 0 {
+			result += ", server certificate issued by " + certs[0].Issuer.String()
+		}
+	}
+	switch status {
+	case http.StatusOK:
+		return result, nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("%s, the token was rejected", result)
+	}
+	return "", fmt.Errorf("%s", result)
+}
+
+// Number of certificates in a PEM bundle
+func countCertificates(b []byte) int {
+	count := 0
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return count
+		}
+		if block.Type == "CERTIFICATE" {
+			count++
+		}
+	}
+}
+
+func orNone(s, none string) string {
+	if s == "" {
+		return none
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("OWL_TOKEN", "")
+	t.Setenv("OWL_PROFILE", "")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The same token header every query of the tools sends
+		if r.Header.Get("X-Access-Token") != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data": {"__typename": "Query"}}`))
+	}))
+	defer server.Close()
+	ca := filepath.Join(dir, "ca.pem")
+	os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	path := filepath.Join(dir, "config.json")
+	write := func(token string) {
+		conf := `{"token": "` + token + `", "endpoint": "` + server.URL + `", "network": {"caFile": "` + ca + `", "minTLSVersion": "1.3"}}`
+		if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("good")
+	c := Common{ConfigPath: path}
+	var out strings.Builder
+	if err := c.Doctor(context.Background(), &out); err != nil {
+		t.Fatalf("Received an error: %s\n%s", err, out.String())
+	}
+	for _, want := range []string{path, server.URL, "Token:", "set", ca + " (1 certificates)", "Client cert:  none", "Min TLS:      1.3", "HTTP 200", "TLS 1.3"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Doctor output is missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// A rejected token fails the check but still prints every setting
+	write("bad")
+	out.Reset()
+	if err := c.Doctor(context.Background(), &out); err == nil {
+		t.Errorf("Expected an error for a rejected token")
+	}
+	if !strings.Contains(out.String(), "HTTP 401") || !strings.Contains(out.String(), "Proxy:") {
+		t.Errorf("Expected the settings and the 401, got:\n%s", out.String())
+	}
+}
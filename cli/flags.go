@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/config"
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Usage is the part of a tool's usage line covering the Common flags
+const Usage = "[--verbose | --quiet] [--log-format text|json] [--deadline 2m] [--config file] [--profile name] [--record dir | --replay dir] [--no-cache]"
+
+// Common - Flags every tool accepts
+type Common struct {
+	Verbose    bool
+	Quiet      bool
+	LogFormat  string
+	Deadline   time.Duration
+	ConfigPath string
+	Profile    string
+	Record     string
+	Replay     string
+	NoCache    bool
+}
+
+// Register adds the common flags to fs
+func (c *Common) Register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Verbose, "verbose", false, "Log every graphQL request and the request counts")
+	fs.BoolVar(&c.Quiet, "quiet", false, "Only log errors")
+	fs.StringVar(&c.LogFormat, "log-format", "text", "Log records to stderr as text or json")
+	fs.DurationVar(&c.Deadline, "deadline", 0, "Give up after this long and report what was done, e.g. 2m (0 for no deadline)")
+	fs.StringVar(&c.ConfigPath, "config", "", "Config file to use instead of ./config.json (or set OWL_CONFIG)")
+	fs.StringVar(&c.Profile, "profile", "", "Named org profile from the config (or set OWL_PROFILE)")
+	fs.StringVar(&c.Record, "record", "", "Save every graphQL request/response pair to this directory")
+	fs.StringVar(&c.Replay, "replay", "", "Answer graphQL requests from a --record directory instead of the network")
+	fs.BoolVar(&c.NoCache, "no-cache", false, "Always fetch from the API, neither reading nor writing the response cache")
+}
+
+// LoadConfig resolves the config for the --config and --profile flags.
+// No token is needed when replaying a recording.
+func (c *Common) LoadConfig() (config.Config, error) {
+	return config.Load(config.Options{Path: c.ConfigPath, Profile: c.Profile, NoToken: c.Replay != ""})
+}
+
+// NewClient builds the graphQL client for conf, recording or replaying when asked
+func (c *Common) NewClient(conf config.Config) (*graphql.Client, error) {
+	if c.Record != "" && c.Replay != "" {
+		return nil, errors.New("--record and --replay cannot be used together")
+	}
+	opts := conf.ClientOptions()
+	transport, err := graphql.NewTransport(conf.Network)
+	if err != nil {
+		return nil, err
+	}
+	opts.Transport = transport
+	opts.Record = c.Record
+	opts.Replay = c.Replay
+	if conf.Cache.Enabled && !c.NoCache {
+		dir, err := conf.CacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("finding the cache directory: %w", err)
+		}
+		opts.Cache = graphql.NewCache(dir, time.Minute*time.Duration(conf.Cache.TTLMinutes))
+	}
+	opts.Logger = slog.Default()
+	return graphql.NewClient(opts), nil
+}
+
+// CacheCommand runs "cache clear", which empties the response cache of the configured profile
+func (c *Common) CacheCommand(args []string) error {
+	if len(args) != 1 || args[0] != "clear" {
+		return errors.New("unknown cache command, use: cache clear")
+	}
+	// Clearing the cache never talks to the API, so no token is needed
+	conf, err := config.Load(config.Options{Path: c.ConfigPath, Profile: c.Profile, NoToken: true})
+	if err != nil {
+		return err
+	}
+	dir, err := conf.CacheDir()
+	if err != nil {
+		return err
+	}
+	removed, err := graphql.NewCache(dir, 0).Clear()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d cached responses from %s\n", removed, dir)
+	return nil
+}
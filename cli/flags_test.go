@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommonFlags(t *testing.T) {
+	var c Common
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.Register(fs)
+	if err := fs.Parse([]string{"--verbose", "--deadline", "90s", "--profile", "acme", "--replay", "fixtures", "4656"}); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if !c.Verbose || c.Deadline.Seconds() != 90 || c.Profile != "acme" || c.Replay != "fixtures" {
+		t.Errorf("Flags were not parsed, got: %+v", c)
+	}
+	if fs.Arg(0) != "4656" {
+		t.Errorf("Positional arguments were lost, got: %v", fs.Args())
+	}
+}
+
+func TestReplayNeedsNoToken(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("OWL_TOKEN", "")
+	t.Setenv("OWL_PROFILE", "")
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"timeout": 15}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Common{ConfigPath: path}
+	if _, err := c.LoadConfig(); err == nil {
+		t.Errorf("Expected a missing token error for a live run")
+	}
+	c.Replay = dir
+	conf, err := c.LoadConfig()
+	if err != nil {
+		t.Fatalf("Replay should not need a token, got: %s", err)
+	}
+	c.Record = dir
+	if _, err := c.NewClient(conf); err == nil {
+		t.Errorf("Expected an error for --record with --replay")
+	}
+}
+
+func TestCacheCommand(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("OWL_TOKEN", "")
+	t.Setenv("OWL_PROFILE", "")
+	cacheDir := filepath.Join(dir, "cache")
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"cache": {"dir": "`+cacheDir+`"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(cacheDir, 0o700)
+	os.WriteFile(filepath.Join(cacheDir, "abc.json"), []byte(`{}`), 0o600)
+
+	c := Common{ConfigPath: path}
+	if err := c.CacheCommand([]string{"purge"}); err == nil {
+		t.Errorf("Expected an error for an unknown cache command")
+	}
+	// Clearing needs no token
+	if err := c.CacheCommand([]string{"clear"}); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if entries, _ := os.ReadDir(cacheDir); len(entries) != 0 {
+		t.Errorf("Cache was not cleared, %d entries left", len(entries))
+	}
+}
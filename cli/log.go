@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Level returns the log level picked by --verbose and --quiet: debug, info by default, or errors only
+func (c *Common) Level() slog.Level {
+	switch {
+	case c.Quiet:
+		return slog.LevelError
+	case c.Verbose:
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// SetupLogging makes a logger for the --verbose, --quiet and --log-format flags the slog default.
+// Logs go to stderr so the report on stdout can be piped.
+func (c *Common) SetupLogging() error {
+	if c.Verbose && c.Quiet {
+		return errors.New("--verbose and --quiet cannot be used together")
+	}
+	logger, err := NewLogger(os.Stderr, c.Level(), c.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// NewLogger builds a logger writing text or JSON records at or above level to w
+func NewLogger(w io.Writer, level slog.Level, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown --log-format %q, use text or json", format)
+}
+
+// LogStats logs the requests, retries, rate limit wait and cache use of client with the timings at info level,
+// only the per-request records need --verbose
+func LogStats(client *graphql.Client) {
+	stats := client.Stats()
+	attrs := []any{"requests", stats.Requests, "retries", stats.Retries, "rate_limited", stats.Limited}
+	if client.Cache != nil {
+		cache := client.Cache.Stats()
+		attrs = append(attrs, "cache_hits", cache.Hits, "cache_misses", cache.Misses, "not_cacheable", cache.Skipped)
+	}
+	slog.Info("graphql stats", attrs...)
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+func TestLevel(t *testing.T) {
+	cases := []struct {
+		c    Common
+		want slog.Level
+	}{
+		{Common{}, slog.LevelInfo},
+		{Common{Verbose: true}, slog.LevelDebug},
+		{Common{Quiet: true}, slog.LevelError},
+	}
+	for _, tc := range cases {
+		if got := tc.c.Level(); got != tc.want {
+			t.Errorf("Wrong level for %+v, got: %s, want: %s", tc.c, got, tc.want)
+		}
+	}
+	both := Common{Verbose: true, Quiet: true}
+	if err := both.SetupLogging(); err == nil {
+		t.Error("Expected an error for --verbose with --quiet")
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, slog.LevelInfo, "json")
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	logger.Debug("hidden")
+	logger.Info("fetched", "query", "sites")
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Did not write one JSON record, got %q: %s", buf.String(), err)
+	}
+	if record["msg"] != "fetched" || record["query"] != "sites" {
+		t.Errorf("Wrong record, got: %v", record)
+	}
+
+	buf.Reset()
+	logger, _ = NewLogger(&buf, slog.LevelInfo, "text")
+	logger.Warn("slow", "query", "sites")
+	if !strings.Contains(buf.String(), "level=WARN msg=slow query=sites") {
+		t.Errorf("Wrong text record, got: %q", buf.String())
+	}
+
+	if _, err := NewLogger(&buf, slog.LevelInfo, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestLogStats(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, slog.LevelInfo, "text")
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	// The rate limit wait and cache counts are part of the timing output without --verbose
+	LogStats(graphql.NewClient(graphql.Options{Cache: graphql.NewCache(t.TempDir(), time.Hour)}))
+	for _, attr := range []string{"level=INFO msg=\"graphql stats\"", "rate_limited=0s", "cache_hits=0", "cache_misses=0"} {
+		if !strings.Contains(buf.String(), attr) {
+			t.Errorf("Stats are missing %s, got: %q", attr, buf.String())
+		}
+	}
+}
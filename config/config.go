@@ -0,0 +1,346 @@
+// Package config resolves the settings shared by the OwlCode tools from config files,
+// named org profiles and environment variables.
+//
+// Layers are applied lowest precedence first:
+//
+//	<user config dir>/owlcode/config.json
+//	./config.json, or the file given with --config / OWL_CONFIG
+//	the selected profile from the "profiles" block of the merged files
+//	OWL_TOKEN, OWL_ENDPOINT, OWL_TIMEOUT, OWL_TIMEZONE environment variables
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Config - The resolved settings for one org
+type Config struct {
+	Token      string    // Access token
+	Timeout    int       // HTTP timeout in seconds
+	Endpoint   string    // GraphQL URL, defaults to the production endpoint
+	BoundMulti float32   // Bound Multiplier for the site bounding boxes
+	Timezone   string    // IANA name used to print times, e.g. America/Chicago
+	Retry      Retry     // Retry rules for transient API errors
+	RateLimit  RateLimit // Client side rate limit
+	Cache      Cache     // On-disk response cache
+	Chunk      Chunk     // Splitting of long time windows into several queries
+	Batch      Batch     // Packing of many small queries into one request
+	Network    Network   // Proxy and TLS settings for reaching the API
+
+	Profile string   `json:"-"` // Name of the profile in use, empty for none
+	Sources []string `json:"-"` // Files and variables that were applied, lowest precedence first
+}
+
+// Retry - Retry rules for transient API errors, zero values use the client defaults
+type Retry struct {
+	MaxAttempts int // Total tries per query, including the first
+	BaseDelayMs int // Backoff before the first retry, doubled after each retry
+	MaxDelayMs  int // Cap on the backoff between tries
+}
+
+// RateLimit - Client side rate limit, Endpoints overrides it for particular graphQL URLs
+type RateLimit struct {
+	RequestsPerSecond float64 // Negative turns limiting off
+	Burst             int
+	Endpoints         map[string]graphql.RateLimit
+}
+
+// Cache - On-disk response cache settings
+type Cache struct {
+	Enabled    bool
+	Dir        string // Defaults to <user cache dir>/owlcode
+	TTLMinutes int    // How long a cached response is reused
+}
+
+// Chunk - How long time windows are split into queries
+type Chunk struct {
+	Hours       int // Longest window fetched by one query, 0 fetches any window whole
+	Concurrency int // Chunks fetched at once
+}
+
+// Batch - How many small per-device queries are packed into one request
+type Batch struct {
+	Size          int // Most devices per request
+	MaxResponseKB int // Response size to stay under, requests shrink once devices are seen to be large. 0 for no limit
+}
+
+// Network - Proxy, extra root CAs, client certificate and minimum TLS version, paths are relative to the working directory
+type Network = graphql.Network
+
+// Options - Where to look for config, usually filled from the --config and --profile flags
+type Options struct {
+	Path    string // Config file to use instead of ./config.json
+	Profile string // Named profile to apply
+	NoToken bool   // Skip the token check, e.g. when replaying recorded responses
+}
+
+// Env holds the names of the environment variables read by Load
+var Env = struct {
+	Config, Profile, Token, Endpoint, Timeout, Timezone string
+}{"OWL_CONFIG", "OWL_PROFILE", "OWL_TOKEN", "OWL_ENDPOINT", "OWL_TIMEOUT", "OWL_TIMEZONE"}
+
+// Keys whose children are names (profiles, endpoint URLs) rather than config fields
+var namedKeys = map[string]bool{"profiles": true, "endpoints": true}
+
+// UserPath returns the config file in the user config directory, e.g. ~/.config/owlcode/config.json
+func UserPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "owlcode", "config.json"), nil
+}
+
+// Load resolves the config from every layer, applies the selected profile and validates the result.
+// Fields missing from every layer take their value from Defaults.
+func Load(opts Options) (Config, error) {
+	merged, err := defaultLayer()
+	if err != nil {
+		return Config{}, err
+	}
+	var sources []string
+
+	// User wide config first, so the local or named file can override it
+	if userPath, err := UserPath(); err == nil {
+		ok, err := mergeFile(merged, userPath, false)
+		if err != nil {
+			return Config{}, err
+		}
+		if ok {
+			sources = append(sources, userPath)
+		}
+	}
+	path, required := opts.Path, true
+	var ok bool
+	if path == "" {
+		path = os.Getenv(Env.Config)
+	}
+	if path == "" {
+		path, required = "config.json", false
+	}
+	ok, err = mergeFile(merged, path, required)
+	if err != nil {
+		return Config{}, err
+	}
+	if ok {
+		sources = append(sources, path)
+	}
+
+	// Pick the profile: flag, then environment, then the "profile" key of the files
+	profiles, _ := merged["profiles"].(map[string]interface{})
+	name := opts.Profile
+	if name == "" {
+		name = os.Getenv(Env.Profile)
+	}
+	if name == "" {
+		name, _ = merged["profile"].(string)
+	}
+	delete(merged, "profiles")
+	delete(merged, "profile")
+	if name != "" {
+		profile, ok := profiles[name].(map[string]interface{})
+		if !ok {
+			return Config{}, fmt.Errorf("profile %q not found, available profiles: %s", name, strings.Join(profileNames(profiles), ", "))
+		}
+		merge(merged, profile)
+		sources = append(sources, "profile "+name)
+	}
+
+	conf, err := decode(merged)
+	if err != nil {
+		return Config{}, err
+	}
+	conf.Profile = name
+	conf.Sources = sources
+	if err := conf.applyEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := conf.validate(!opts.NoToken); err != nil {
+		return Config{}, err
+	}
+	return conf, nil
+}
+
+// The Defaults as the bottom config layer
+func defaultLayer() (map[string]interface{}, error) {
+	b, err := json.Marshal(Defaults)
+	if err != nil {
+		return nil, err
+	}
+	var layer map[string]interface{}
+	if err := json.Unmarshal(b, &layer); err != nil {
+		return nil, err
+	}
+	return normalize(layer), nil
+}
+
+// Overrides fields set in the environment, used for tokens kept out of files
+func (c *Config) applyEnv() error {
+	if v := os.Getenv(Env.Token); v != "" {
+		c.Token = v
+		c.Sources = append(c.Sources, Env.Token)
+	}
+	if v := os.Getenv(Env.Endpoint); v != "" {
+		c.Endpoint = v
+		c.Sources = append(c.Sources, Env.Endpoint)
+	}
+	if v := os.Getenv(Env.Timeout); v != "" {
+		timeout, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number of seconds, got %q", Env.Timeout, v)
+		}
+		c.Timeout = timeout
+		c.Sources = append(c.Sources, Env.Timeout)
+	}
+	if v := os.Getenv(Env.Timezone); v != "" {
+		c.Timezone = v
+		c.Sources = append(c.Sources, Env.Timezone)
+	}
+	return nil
+}
+
+// ClientOptions converts the config into the settings for a graphql.Client
+func (c Config) ClientOptions() graphql.Options {
+	// 0 turns the response size limit off in the config, a negative MaxBytes does in the client
+	maxBytes := c.Batch.MaxResponseKB * 1024
+	if maxBytes == 0 {
+		maxBytes = -1
+	}
+	return graphql.Options{
+		Endpoint: c.Endpoint,
+		Token:    c.Token,
+		Timeout:  time.Second * time.Duration(c.Timeout),
+		Retry: graphql.RetryPolicy{
+			MaxAttempts: c.Retry.MaxAttempts,
+			BaseDelay:   time.Millisecond * time.Duration(c.Retry.BaseDelayMs),
+			MaxDelay:    time.Millisecond * time.Duration(c.Retry.MaxDelayMs),
+		},
+		Limits: graphql.RateLimits{
+			Default:   graphql.RateLimit{RequestsPerSecond: c.RateLimit.RequestsPerSecond, Burst: c.RateLimit.Burst},
+			Endpoints: c.RateLimit.Endpoints,
+		},
+		Chunking: graphql.Chunking{
+			Size:        time.Hour * time.Duration(c.Chunk.Hours),
+			Concurrency: c.Chunk.Concurrency,
+		},
+		Batching: graphql.Batching{
+			Size:     c.Batch.Size,
+			MaxBytes: maxBytes,
+		},
+	}
+}
+
+// CacheDir returns the configured cache directory, or the owlcode folder of the user cache directory
+func (c Config) CacheDir() (string, error) {
+	if c.Cache.Dir != "" {
+		return c.Cache.Dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "owlcode"), nil
+}
+
+// Location returns the configured timezone, or local time when none is set
+func (c Config) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// Reads a JSON config file and merges it into dst. A missing file is only an error when required.
+func mergeFile(dst map[string]interface{}, path string, required bool) (bool, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading config: %w", err)
+	}
+	var layer map[string]interface{}
+	if err := json.Unmarshal(b, &layer); err != nil {
+		return false, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if unknown := checkKeys(layer); len(unknown) > 0 {
+		return false, fmt.Errorf("unknown config keys in %s: %s", path, strings.Join(unknown, ", "))
+	}
+	merge(dst, normalize(layer))
+	return true, nil
+}
+
+// Lower cases field keys, as encoding/json matches them case-insensitively, so layers
+// that spell a key differently still override each other
+func normalize(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		key := strings.ToLower(k)
+		child, ok := v.(map[string]interface{})
+		switch {
+		case ok && namedKeys[key]:
+			named := make(map[string]interface{}, len(child))
+			for name, nv := range child {
+				if nm, ok := nv.(map[string]interface{}); ok {
+					nv = normalize(nm)
+				}
+				named[name] = nv
+			}
+			v = named
+		case ok:
+			v = normalize(child)
+		}
+		out[key] = v
+	}
+	return out
+}
+
+// Deep merges src into dst, values in src win
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcChild, srcOK := v.(map[string]interface{})
+		dstChild, dstOK := dst[k].(map[string]interface{})
+		if srcOK && dstOK {
+			merge(dstChild, srcChild)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Turns the merged layers into a Config
+func decode(m map[string]interface{}) (Config, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Config{}, err
+	}
+	var conf Config
+	if err := json.Unmarshal(b, &conf); err != nil {
+		return Config{}, fmt.Errorf("parsing config: %w", err)
+	}
+	return conf, nil
+}
+
+// Sorted profile names for error messages
+func profileNames(profiles map[string]interface{}) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{"(none)"}
+	}
+	return names
+}
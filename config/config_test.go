@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Points the user config dir at a temp dir and clears the OWL_ variables
+func setup(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	for _, name := range []string{Env.Config, Env.Profile, Env.Token, Env.Endpoint, Env.Timeout, Env.Timezone} {
+		t.Setenv(name, "")
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, body string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := setup(t)
+	userPath, _ := UserPath()
+	writeFile(t, userPath, `{
+		"token": "user token",
+		"timeout": 30,
+		"boundMulti": 2,
+		"profiles": {
+			"Acme": {"Token": "acme token", "endpoint": "http://staging.test/graphql"}
+		}
+	}`)
+	local := filepath.Join(dir, "local.json")
+	writeFile(t, local, `{"Timeout": 15, "timezone": "America/Chicago"}`)
+
+	// The named file overrides the user file, key case does not matter
+	conf, err := Load(Options{Path: local})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if conf.Token != "user token" || conf.Timeout != 15 || conf.BoundMulti != 2 || conf.Timezone != "America/Chicago" {
+		t.Errorf("Layers did not merge, got: %+v", conf)
+	}
+
+	// A profile overrides both files
+	conf, err = Load(Options{Path: local, Profile: "Acme"})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if conf.Token != "acme token" || conf.Endpoint != "http://staging.test/graphql" || conf.Timeout != 15 {
+		t.Errorf("Profile was not applied, got: %+v", conf)
+	}
+	if len(conf.Sources) != 3 || conf.Sources[2] != "profile Acme" {
+		t.Errorf("Did not record the sources, got: %v", conf.Sources)
+	}
+
+	// The environment overrides everything
+	t.Setenv(Env.Token, "env token")
+	t.Setenv(Env.Profile, "Acme")
+	conf, err = Load(Options{Path: local})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if conf.Token != "env token" || conf.Profile != "Acme" {
+		t.Errorf("Environment was not applied, got: %+v", conf)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := setup(t)
+	local := filepath.Join(dir, "local.json")
+	writeFile(t, local, `{"token": "t", "profiles": {"acme": {}, "globex": {}}}`)
+
+	_, err := Load(Options{Path: local, Profile: "initech"})
+	if err == nil || !strings.Contains(err.Error(), "acme, globex") {
+		t.Errorf("Missing profile should list the profiles, got: %v", err)
+	}
+	if _, err := Load(Options{Path: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Errorf("Expected an error for a missing --config file")
+	}
+	t.Setenv(Env.Timeout, "soon")
+	if _, err := Load(Options{Path: local}); err == nil {
+		t.Errorf("Expected an error for a bad %s", Env.Timeout)
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Defaults are applied beneath every other layer, so a field left out of the files takes these values
+var Defaults = Config{
+	Timeout:    15,
+	Endpoint:   graphql.DefaultEndpoint,
+	BoundMulti: 2,
+	Retry: Retry{
+		MaxAttempts: graphql.DefaultRetryPolicy.MaxAttempts,
+		BaseDelayMs: int(graphql.DefaultRetryPolicy.BaseDelay / time.Millisecond),
+		MaxDelayMs:  int(graphql.DefaultRetryPolicy.MaxDelay / time.Millisecond),
+	},
+	RateLimit: RateLimit{
+		RequestsPerSecond: graphql.DefaultRateLimit.RequestsPerSecond,
+		Burst:             graphql.DefaultRateLimit.Burst,
+	},
+	Cache: Cache{
+		TTLMinutes: 24 * 60,
+	},
+	Chunk: Chunk{
+		Hours:       24,
+		Concurrency: 4,
+	},
+	Batch: Batch{
+		Size:          graphql.DefaultBatching.Size,
+		MaxResponseKB: graphql.DefaultBatching.MaxBytes / 1024,
+	},
+	Network: Network{
+		MinTLSVersion: "1.2",
+	},
+}
+
+// FieldError - A config field with a bad value
+type FieldError struct {
+	Field   string // e.g. retry.maxAttempts
+	Problem string
+}
+
+func (e *FieldError) Error() string {
+	return "config field " + e.Field + " " + e.Problem
+}
+
+// Validate checks every field and returns all the problems found at once
+func (c Config) Validate() error {
+	return c.validate(true)
+}
+
+func (c Config) validate(requireToken bool) error {
+	var errs []error
+	bad := func(field, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{field, fmt.Sprintf(format, args...)})
+	}
+	if requireToken && strings.TrimSpace(c.Token) == "" {
+		bad("token", "is required, set it in a config file or with %s", Env.Token)
+	}
+	if c.Timeout <= 0 {
+		bad("timeout", "must be a positive number of seconds, got %d", c.Timeout)
+	}
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		bad("endpoint", "must be an http(s) URL, got %q", c.Endpoint)
+	}
+	if c.BoundMulti <= 0 {
+		bad("boundMulti", "must be positive, got %v (0 collapses every site to a point)", c.BoundMulti)
+	}
+	if _, err := c.Location(); err != nil {
+		bad("timezone", "must be an IANA timezone such as America/Chicago, got %q", c.Timezone)
+	}
+	if c.Retry.MaxAttempts <= 0 {
+		bad("retry.maxAttempts", "must be at least 1, got %d", c.Retry.MaxAttempts)
+	}
+	if c.Retry.BaseDelayMs <= 0 {
+		bad("retry.baseDelayMs", "must be positive, got %d", c.Retry.BaseDelayMs)
+	}
+	if c.Retry.MaxDelayMs < c.Retry.BaseDelayMs {
+		bad("retry.maxDelayMs", "must be at least retry.baseDelayMs (%d), got %d", c.Retry.BaseDelayMs, c.Retry.MaxDelayMs)
+	}
+	if c.RateLimit.RequestsPerSecond == 0 {
+		bad("rateLimit.requestsPerSecond", "must not be 0, use a negative value to turn limiting off")
+	}
+	if c.RateLimit.Burst <= 0 {
+		bad("rateLimit.burst", "must be at least 1, got %d", c.RateLimit.Burst)
+	}
+	for endpoint, limit := range c.RateLimit.Endpoints {
+		if limit.RequestsPerSecond == 0 {
+			bad("rateLimit.endpoints."+endpoint+".requestsPerSecond", "must not be 0, use a negative value to turn limiting off")
+		}
+		if limit.Burst < 0 {
+			bad("rateLimit.endpoints."+endpoint+".burst", "must not be negative, got %d", limit.Burst)
+		}
+	}
+	if c.Cache.TTLMinutes <= 0 {
+		bad("cache.ttlMinutes", "must be positive, got %d (set cache.enabled to false to turn caching off)", c.Cache.TTLMinutes)
+	}
+	if c.Chunk.Hours < 0 {
+		bad("chunk.hours", "must not be negative, got %d (use 0 to fetch every window whole)", c.Chunk.Hours)
+	}
+	if c.Chunk.Concurrency <= 0 {
+		bad("chunk.concurrency", "must be at least 1, got %d", c.Chunk.Concurrency)
+	}
+	if c.Batch.Size <= 0 {
+		bad("batch.size", "must be at least 1, got %d", c.Batch.Size)
+	}
+	if c.Batch.MaxResponseKB < 0 {
+		bad("batch.maxResponseKB", "must not be negative, got %d (use 0 for no limit)", c.Batch.MaxResponseKB)
+	}
+	if c.Network.Proxy != "" {
+		if _, err := c.Network.ProxyFor(c.Endpoint); err != nil {
+			bad("network.proxy", "%s", err)
+		}
+	}
+	if _, err := graphql.TLSVersion(c.Network.MinTLSVersion); err != nil {
+		bad("network.minTLSVersion", "must be 1.2 or 1.3, got %q", c.Network.MinTLSVersion)
+	}
+	// Load the files now so a missing or malformed one is reported at startup, not on the first request
+	if _, err := c.Network.RootCAs(); err != nil {
+		bad("network.caFile", "%s", err)
+	}
+	if _, err := c.Network.ClientCertificate(); err != nil {
+		bad("network.certFile", "%s", err)
+	}
+	return errors.Join(errs...)
+}
+
+// Checks a config file layer for keys that do not match any field, e.g. a misspelt "boundMult"
+func checkKeys(layer map[string]interface{}) []string {
+	var unknown []string
+	configType := reflect.TypeOf(Config{})
+	for key, value := range layer {
+		switch strings.ToLower(key) {
+		case "profile":
+		case "profiles":
+			profiles, _ := value.(map[string]interface{})
+			for name, profile := range profiles {
+				if p, ok := profile.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownKeys(p, configType, key+"."+name+".")...)
+				}
+			}
+		default:
+			unknown = append(unknown, unknownKeys(map[string]interface{}{key: value}, configType, "")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Walks m against the fields of t, returning the dotted path of every key with no matching field
+func unknownKeys(m map[string]interface{}, t reflect.Type, prefix string) []string {
+	var unknown []string
+	for key, value := range m {
+		field, ok := fieldByKey(t, key)
+		if !ok {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		child, isMap := value.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			unknown = append(unknown, unknownKeys(child, field.Type, prefix+key+".")...)
+		case field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct:
+			for name, entry := range child {
+				if e, ok := entry.(map[string]interface{}); ok {
+					unknown = append(unknown, unknownKeys(e, field.Type.Elem(), prefix+key+"."+name+".")...)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+// Finds the field encoding/json would decode key into
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Name
+		if tag := field.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		if field.IsExported() && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
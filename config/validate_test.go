@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	dir := setup(t)
+	local := filepath.Join(dir, "local.json")
+	writeFile(t, local, `{"token": "t"}`)
+
+	conf, err := Load(Options{Path: local})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// A missing BoundMulti used to become 0 and collapse every site to a point
+	if conf.BoundMulti != Defaults.BoundMulti || conf.Timeout != Defaults.Timeout || conf.Endpoint != Defaults.Endpoint {
+		t.Errorf("Defaults were not applied, got: %+v", conf)
+	}
+	if conf.Retry != Defaults.Retry || conf.RateLimit.Burst != Defaults.RateLimit.Burst || conf.Chunk != Defaults.Chunk || conf.Batch != Defaults.Batch {
+		t.Errorf("Nested defaults were not applied, got: %+v %+v", conf.Retry, conf.RateLimit)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	dir := setup(t)
+	local := filepath.Join(dir, "local.json")
+	writeFile(t, local, `{"timeout": -1, "boundMulti": 0, "timezone": "Mars/Olympus", "retry": {"maxAttempts": 0}, "chunk": {"hours": -1, "concurrency": 0}, "batch": {"size": 0}}`)
+
+	_, err := Load(Options{Path: local})
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	// Every bad field is reported at once, by name
+	for _, field := range []string{"token", "timeout", "boundMulti", "timezone", "retry.maxAttempts", "chunk.hours", "chunk.concurrency", "batch.size"} {
+		if !strings.Contains(err.Error(), "config field "+field+" ") {
+			t.Errorf("Did not report field %s, got: %s", field, err)
+		}
+	}
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) {
+		t.Errorf("Expected FieldErrors, got: %T", err)
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	dir := setup(t)
+	local := filepath.Join(dir, "local.json")
+	writeFile(t, local, `{
+		"token": "t",
+		"boundMult": 2,
+		"retry": {"maxAtempts": 3},
+		"rateLimit": {"endpoints": {"http://a.test": {"rps": 1}}},
+		"profiles": {"acme": {"tokn": "x"}}
+	}`)
+
+	_, err := Load(Options{Path: local})
+	if err == nil {
+		t.Fatal("Expected an unknown key error")
+	}
+	for _, key := range []string{"boundMult", "retry.maxAtempts", "rateLimit.endpoints.http://a.test.rps", "profiles.acme.tokn"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Did not report unknown key %s, got: %s", key, err)
+		}
+	}
+}
+
+func TestLoadNetwork(t *testing.T) {
+	dir := setup(t)
+	local := filepath.Join(dir, "local.json")
+	ca := filepath.Join(dir, "ca.pem")
+	writeFile(t, ca, "not a certificate")
+	writeFile(t, local, `{"token": "t", "network": {"proxy": "proxy.example.com", "caFile": "`+ca+`", "certFile": "client.pem", "minTLSVersion": "1.0"}}`)
+
+	_, err := Load(Options{Path: local})
+	if err == nil {
+		t.Fatal("Expected network validation errors")
+	}
+	for _, field := range []string{"network.proxy", "network.caFile", "network.certFile", "network.minTLSVersion"} {
+		if !strings.Contains(err.Error(), "config field "+field+" ") {
+			t.Errorf("Did not report field %s, got: %s", field, err)
+		}
+	}
+
+	writeFile(t, local, `{"token": "t", "network": {"proxy": "http://proxy.example.com:3128"}}`)
+	conf, err := Load(Options{Path: local})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if conf.Network.Proxy != "http://proxy.example.com:3128" || conf.Network.MinTLSVersion != Defaults.Network.MinTLSVersion {
+		t.Errorf("Network settings were not loaded, got: %+v", conf.Network)
+	}
+	if opts := conf.ClientOptions(); opts.Transport != nil {
+		t.Errorf("Expected the transport to be left to the caller, got: %v", opts.Transport)
+	}
+}
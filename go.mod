@@ -0,0 +1,3 @@
+module github.com/thewhofan23/OwlCode
+
+go 1.24
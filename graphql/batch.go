@@ -0,0 +1,281 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Batching - How ExecuteBatch packs items into documents
+type Batching struct {
+	Size     int // Most items per document, DefaultBatching.Size when zero
+	MaxBytes int // Response size to stay under, documents shrink once items are seen to be large. Negative for no limit
+}
+
+// DefaultBatching is used for zero fields of a client's Batching
+var DefaultBatching = Batching{Size: 20, MaxBytes: 4 << 20}
+
+// Batch - A root field queried once per item, packed under aliases into as few documents as fit
+type Batch struct {
+	Name   string            // Operation name, used in logs and recordings
+	Field  string            // One root field and its selection using $variables, e.g. `device(id: $deviceId) { name }`
+	Types  map[string]string // GraphQL type of every variable Field uses, e.g. {"deviceId": "Int64!"}
+	Shared Variables         // Variables with the same value for every item, declared once per document
+}
+
+var variablePattern = regexp.MustCompile(`\$(\w+)`)
+
+// Alias of the item at index i of a document
+func batchAlias(i int) string {
+	return "b" + strconv.Itoa(i)
+}
+
+// Builds one document querying Field once per item under the aliases b0, b1, ...
+// Per-item variables are renamed with the item index so items never share a value by accident.
+func (b Batch) document(items []Variables) (Request, error) {
+	vars := Variables{}
+	var decls []string
+	declare := func(name, typeName string) error {
+		if typeName == "" {
+			return fmt.Errorf("batch %s: no type given for variable $%s", b.Name, name)
+		}
+		decls = append(decls, "$"+name+": "+typeName)
+		return nil
+	}
+	sharedNames := make([]string, 0, len(b.Shared))
+	for name := range b.Shared {
+		sharedNames = append(sharedNames, name)
+	}
+	sort.Strings(sharedNames)
+	for _, name := range sharedNames {
+		if err := declare(name, b.Types[name]); err != nil {
+			return Request{}, err
+		}
+		vars[name] = b.Shared[name]
+	}
+
+	var fields strings.Builder
+	for i, item := range items {
+		suffix := "_" + strconv.Itoa(i)
+		itemNames := make([]string, 0, len(item))
+		for name := range item {
+			itemNames = append(itemNames, name)
+		}
+		sort.Strings(itemNames)
+		for _, name := range itemNames {
+			if err := declare(name+suffix, b.Types[name]); err != nil {
+				return Request{}, err
+			}
+			vars[name+suffix] = item[name]
+		}
+		field := variablePattern.ReplaceAllStringFunc(b.Field, func(v string) string {
+			if _, shared := b.Shared[v[1:]]; shared {
+				return v
+			}
+			return v + suffix
+		})
+		fields.WriteString("\t" + batchAlias(i) + ": " + field + "\n")
+	}
+	query := "query " + b.Name + "(" + strings.Join(decls, ", ") + ") {\n" + fields.String() + "}"
+	return Request{Query: query, Variables: vars}, nil
+}
+
+// ExecuteBatch queries b's Field once for each item of per-item variables, packing the items into aliased
+// documents so many small queries cost few round trips. Results and errors are returned per item, in item
+// order: an item whose field resolved to null gets a ResponseError, one with data and errors a partial one,
+// and a document that fails as a whole in a way one item can cause (see splittable) is split in half and tried
+// again until the failure is down to the items that cause it, so one broken item never fails the others.
+//
+// Documents hold up to the client's Batching.Size items, fewer once items of this Batch have been seen to be
+// large enough that a full document would pass Batching.MaxBytes.
+func ExecuteBatch[T any](ctx context.Context, c *Client, b Batch, items []Variables) ([]T, []error) {
+	results := make([]T, len(items))
+	errs := make([]error, len(items))
+	for start := 0; start < len(items); {
+		end := min(start+c.batchSize(b.Name), len(items))
+		runBatch(ctx, c, b, items[start:end], results[start:end], errs[start:end])
+		start = end
+	}
+	return results, errs
+}
+
+// ExecuteBatchWindows runs ExecuteBatch once for each of windows, up to the client's Chunking.Concurrency at
+// once, with the window's end and duration as b's shared $endTime and $duration. Windows are usually the chunks
+// of Chunking.Split. Each item gets its results in window order and one error: the first window failing it
+// outright, otherwise its first partial error with the results still there, see IsPartial. Cancellation fails
+// every item.
+func ExecuteBatchWindows[T any](ctx context.Context, c *Client, b Batch, items []Variables, windows []Window) ([][]T, []error) {
+	// Per-item errors are kept in the window results, so fetchWindows only fails on cancellation
+	type window struct {
+		results []T
+		errs    []error
+	}
+	fetched, err := fetchWindows(ctx, c, windows, func(ctx context.Context, w Window) (window, error) {
+		wb := b
+		wb.Shared = Variables{}
+		for name, v := range b.Shared {
+			wb.Shared[name] = v
+		}
+		wb.Shared["endTime"], wb.Shared["duration"] = w.EndMs, w.DurationMs
+		results, errs := ExecuteBatch[T](ctx, c, wb, items)
+		return window{results, errs}, ctx.Err()
+	})
+
+	results := make([][]T, len(items))
+	errs := make([]error, len(items))
+	for i := range items {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		results[i] = make([]T, len(fetched))
+		for w, f := range fetched {
+			results[i][w] = f.results[i]
+			if e := f.errs[i]; e != nil && (errs[i] == nil || IsPartial(errs[i]) && !IsPartial(e)) {
+				errs[i] = e
+			}
+		}
+	}
+	return results, errs
+}
+
+// Sends one document for items and splits the response out into results and errs
+func runBatch[T any](ctx context.Context, c *Client, b Batch, items []Variables, results []T, errs []error) {
+	req, err := b.document(items)
+	if err != nil {
+		fillErrors(errs, err)
+		return
+	}
+	var data map[string]json.RawMessage
+	err = c.Do(ctx, req, &data)
+	if len(items) > 1 && ctx.Err() == nil && splittable(err) {
+		half := len(items) / 2
+		if tooLarge(err) {
+			c.Logger.Debug("graphql batch too large, splitting", "query", b.Name, "items", len(items))
+		} else {
+			c.Logger.Debug("graphql batch failed, splitting", "query", b.Name, "items", len(items), "err", err)
+		}
+		runBatch(ctx, c, b, items[:half], results[:half], errs[:half])
+		runBatch(ctx, c, b, items[half:], results[half:], errs[half:])
+		return
+	}
+	var respErr *ResponseError
+	if err != nil && !errors.As(err, &respErr) {
+		fillErrors(errs, err)
+		return
+	}
+
+	// Sort the graphQL errors out to the items they belong to, by the alias their path starts with
+	itemErrors := map[string][]Error{}
+	var documentErrors []Error
+	if respErr != nil {
+		for _, gqlErr := range respErr.Errors {
+			if len(gqlErr.Path) > 0 {
+				if alias, ok := gqlErr.Path[0].(string); ok {
+					itemErrors[alias] = append(itemErrors[alias], gqlErr)
+					continue
+				}
+			}
+			documentErrors = append(documentErrors, gqlErr)
+		}
+	}
+
+	size := 0
+	for i := range items {
+		alias := batchAlias(i)
+		raw := data[alias]
+		size += len(raw)
+		itemErrs := append(itemErrors[alias], documentErrors...)
+		if len(raw) == 0 || string(raw) == "null" {
+			if len(itemErrs) == 0 {
+				itemErrs = []Error{{Message: "response contained no data", Path: []interface{}{alias}}}
+			}
+			errs[i] = &ResponseError{Errors: itemErrs}
+			continue
+		}
+		if err := json.Unmarshal(raw, &results[i]); err != nil {
+			errs[i] = fmt.Errorf("decoding response data: %w", err)
+			continue
+		}
+		if len(itemErrs) > 0 {
+			errs[i] = &ResponseError{Errors: itemErrs, Partial: true}
+		}
+	}
+	c.observeBatch(b.Name, size/len(items))
+}
+
+// Reports whether a failed document may have failed because of one of its items, so that halving it keeps the
+// failure with the items that cause it: too large, timed out, dropped or answered with no data at all. Other
+// statuses, a bad token, a bad query or throttling that outlasted the retries, would fail every half alike.
+func splittable(err error) bool {
+	var statusErr *StatusError
+	var respErr *ResponseError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &statusErr):
+		return tooLarge(err)
+	case errors.As(err, &respErr):
+		return !respErr.Partial
+	}
+	return true
+}
+
+// Statuses a gateway answers with when a response is too big to build or send in time
+func tooLarge(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	switch statusErr.StatusCode {
+	case http.StatusRequestEntityTooLarge, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func fillErrors(errs []error, err error) {
+	for i := range errs {
+		errs[i] = err
+	}
+}
+
+// Items to put in the next document for a batch, from the configured size and what its items weighed so far
+func (c *Client) batchSize(name string) int {
+	size, maxBytes := c.Batching.Size, c.Batching.MaxBytes
+	if size <= 0 {
+		size = DefaultBatching.Size
+	}
+	if maxBytes == 0 {
+		maxBytes = DefaultBatching.MaxBytes
+	}
+	if maxBytes < 0 {
+		return size
+	}
+	c.batchMu.Lock()
+	perItem := c.batchBytes[name]
+	c.batchMu.Unlock()
+	if perItem > 0 {
+		size = min(size, max(maxBytes/perItem, 1))
+	}
+	return size
+}
+
+// Records the average response bytes per item of a document, smoothed with what was seen before
+func (c *Client) observeBatch(name string, perItem int) {
+	c.batchMu.Lock()
+	defer c.batchMu.Unlock()
+	if c.batchBytes == nil {
+		c.batchBytes = map[string]int{}
+	}
+	if prev := c.batchBytes[name]; prev > 0 {
+		perItem = (prev + perItem) / 2
+	}
+	c.batchBytes[name] = perItem
+}
@@ -0,0 +1,223 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+var deviceBatch = Batch{
+	Name:  "devices",
+	Field: `device(id: $deviceId) { name objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) { intValue } }`,
+	Types: map[string]string{"deviceId": "Int64!", "endTime": "Int64!", "duration": "Int64!"},
+}
+
+type batchDevice struct {
+	Name       string
+	ObjectStat []struct{ IntValue int }
+}
+
+func TestBatchDocument(t *testing.T) {
+	b := deviceBatch
+	b.Shared = Variables{"endTime": 1541168971265, "duration": 3123000}
+	req, err := b.document([]Variables{{"deviceId": 1}, {"deviceId": 2}})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	want := "query devices($duration: Int64!, $endTime: Int64!, $deviceId_0: Int64!, $deviceId_1: Int64!) {\n" +
+		"\tb0: device(id: $deviceId_0) { name objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) { intValue } }\n" +
+		"\tb1: device(id: $deviceId_1) { name objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) { intValue } }\n}"
+	if req.Query != want {
+		t.Errorf("Wrong document, got:\n%s\nwant:\n%s", req.Query, want)
+	}
+	if req.Variables["deviceId_1"] != 2 || req.Variables["endTime"] != 1541168971265 {
+		t.Errorf("Wrong variables, got: %v", req.Variables)
+	}
+
+	b.Types = map[string]string{"deviceId": "Int64!"}
+	if _, err := b.document([]Variables{{"deviceId": 1}}); err == nil {
+		t.Error("Expected an error for an undeclared variable type")
+	}
+}
+
+func TestExecuteBatch(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{FailDevices: []int64{212014918137973}})
+	defer server.Close()
+	client := NewClient(Options{Endpoint: server.URL + mock.Path, Batching: Batching{Size: 2}})
+
+	b := deviceBatch
+	b.Shared = Variables{"endTime": 1541168971265, "duration": 3123000}
+	items := []Variables{{"deviceId": 212014918236538}, {"deviceId": 212014918137973}, {"deviceId": 212014918000001}}
+	results, errs := ExecuteBatch[batchDevice](context.Background(), client, b, items)
+
+	// Three items at two a document is two round trips
+	if client.Stats().Requests != 2 {
+		t.Errorf("Did not batch, got: %d requests, want: 2", client.Stats().Requests)
+	}
+	if errs[0] != nil || results[0].Name != "Truck 1" || len(results[0].ObjectStat) != 9 {
+		t.Errorf("Wrong first item, got: %+v, %v", results[0], errs[0])
+	}
+	// The failed device only fails its own item
+	if errs[1] == nil || IsPartial(errs[1]) || !strings.Contains(errs[1].Error(), "unavailable") {
+		t.Errorf("Expected the failed device error, got: %v", errs[1])
+	}
+	if errs[2] != nil || results[2].Name != "Demo Van" {
+		t.Errorf("Wrong last item, got: %+v, %v", results[2], errs[2])
+	}
+}
+
+func TestExecuteBatchWindows(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{FailDevices: []int64{212014918137973}})
+	defer server.Close()
+	client := NewClient(Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   RateLimits{Default: RateLimit{RequestsPerSecond: -1}},
+		Chunking: Chunking{Size: 30 * time.Minute, Concurrency: 2},
+	})
+
+	items := []Variables{{"deviceId": 212014918236538}, {"deviceId": 212014918137973}}
+	windows := client.Chunking.Split(1541168971265, 3123000)
+	results, errs := ExecuteBatchWindows[batchDevice](context.Background(), client, deviceBatch, items, windows)
+
+	// One document per window, each window's end and duration sent as the shared variables
+	if len(windows) != 2 || client.Stats().Requests != 2 {
+		t.Errorf("Expected a document for each of 2 windows, got: %d requests for %d", client.Stats().Requests, len(windows))
+	}
+	stats := 0
+	for _, r := range results[0] {
+		stats += len(r.ObjectStat)
+	}
+	if errs[0] != nil || len(results[0]) != 2 || results[0][1].Name != "Truck 1" || stats < 9 {
+		t.Errorf("Wrong first item, got: %+v, %v", results[0], errs[0])
+	}
+	if errs[1] == nil || IsPartial(errs[1]) {
+		t.Errorf("Expected the failed device error, got: %v", errs[1])
+	}
+
+	// Cancellation fails every item
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, errs := ExecuteBatchWindows[batchDevice](ctx, client, deviceBatch, items, windows); errs[0] == nil || errs[1] == nil {
+		t.Errorf("Expected every item to fail when cancelled, got: %v", errs)
+	}
+}
+
+func TestExecuteBatchSize(t *testing.T) {
+	// Every item answers with about 1KB, and documents over 3 items are rejected as too large
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req Request
+		json.NewDecoder(r.Body).Decode(&req)
+		items := strings.Count(req.Query, ": device(")
+		if items > 3 {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		data := map[string]interface{}{}
+		for i := 0; i < items; i++ {
+			data[batchAlias(i)] = map[string]string{"name": strings.Repeat("x", 1000)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Batching: Batching{Size: 8, MaxBytes: 2500}, Retry: RetryPolicy{MaxAttempts: 1}})
+	b := Batch{Name: "names", Field: `device(id: $deviceId) { name }`, Types: map[string]string{"deviceId": "Int64!"}}
+	items := make([]Variables, 10)
+	for i := range items {
+		items[i] = Variables{"deviceId": i}
+	}
+	_, errs := ExecuteBatch[batchDevice](context.Background(), client, b, items)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Item %d failed: %s", i, err)
+		}
+	}
+	// The first 8 are rejected, and so is each half of 4, so they go as four documents of 2 (7 requests).
+	// The learnt ~1KB per item then keeps the last 2 items to one document under MaxBytes.
+	if requests.Load() != 8 {
+		t.Errorf("Unexpected requests, got: %d, want: 8", requests.Load())
+	}
+	if got := client.batchSize("names"); got != 2 {
+		t.Errorf("Did not learn the item size, got batch size: %d, want: 2", got)
+	}
+}
+
+func TestExecuteBatchSplitsFailures(t *testing.T) {
+	// Any document carrying device 7 times out at the gateway, or drops the connection when it is alone
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req Request
+		json.NewDecoder(r.Body).Decode(&req)
+		data := map[string]interface{}{}
+		for name, v := range req.Variables {
+			if v == float64(7) {
+				if strings.Count(req.Query, ": device(") == 1 {
+					panic(http.ErrAbortHandler)
+				}
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			data[batchAlias(mustIndex(t, name))] = map[string]string{"name": "ok"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Batching: Batching{Size: 8}, Retry: RetryPolicy{MaxAttempts: 1}})
+	b := Batch{Name: "names", Field: `device(id: $deviceId) { name }`, Types: map[string]string{"deviceId": "Int64!"}}
+	items := make([]Variables, 10)
+	for i := range items {
+		items[i] = Variables{"deviceId": i}
+	}
+	results, errs := ExecuteBatch[batchDevice](context.Background(), client, b, items)
+	for i, err := range errs {
+		if (err != nil) != (i == 7) {
+			t.Errorf("Item %d: got error %v", i, err)
+		}
+		if i != 7 && results[i].Name != "ok" {
+			t.Errorf("Item %d: got %+v", i, results[i])
+		}
+	}
+	// 8 items halve to 4, 2 and 1 around device 7, and the last 2 items go in one document
+	if requests.Load() != 1+2+2+2+1 {
+		t.Errorf("Unexpected requests, got: %d, want: 8", requests.Load())
+	}
+
+	// A rejected token is not down to any one item, so every item fails with the one request
+	requests.Store(0)
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer denied.Close()
+	client = NewClient(Options{Endpoint: denied.URL, Batching: Batching{Size: 8}, Retry: RetryPolicy{MaxAttempts: 1}})
+	_, errs = ExecuteBatch[batchDevice](context.Background(), client, b, items[:8])
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Item %d: expected the 401", i)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("A rejected token was split, got: %d requests, want: 1", requests.Load())
+	}
+}
+
+// The item index of a batched variable such as deviceId_3
+func mustIndex(t *testing.T, name string) int {
+	i, err := strconv.Atoi(name[strings.LastIndex(name, "_")+1:])
+	if err != nil {
+		t.Fatalf("Unexpected variable %q", name)
+	}
+	return i
+}
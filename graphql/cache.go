@@ -0,0 +1,211 @@
+package graphql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Cache - On-disk store of successful query responses, keyed by the normalized query and variables
+type Cache struct {
+	Dir string
+	TTL time.Duration
+
+	hits    atomic.Int64
+	misses  atomic.Int64
+	skipped atomic.Int64
+}
+
+// CacheStats - Counters of how queries were served
+type CacheStats struct {
+	Hits    int64 // Served from disk
+	Misses  int64 // Fetched and then stored
+	Skipped int64 // Not cacheable, e.g. a window ending in the future
+}
+
+// NewCache creates a cache in dir whose entries expire after ttl
+func NewCache(dir string, ttl time.Duration) *Cache {
+	return &Cache{Dir: dir, TTL: ttl}
+}
+
+// Stats returns the hit, miss and skip counts so far
+func (c *Cache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Skipped: c.skipped.Load()}
+}
+
+// Get returns the stored response body for key if it has not expired
+func (c *Cache) Get(key string) ([]byte, bool) {
+	path, ok := c.lookup(key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return body, true
+}
+
+// Put stores a response body under key, writing through a temp file so readers never see half an entry
+func (c *Cache) Put(key string, body []byte) error {
+	entry, err := c.create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := entry.Write(body); err != nil {
+		entry.abort()
+		return err
+	}
+	return entry.commit()
+}
+
+// Open returns the stored response for key as a stream if it has not expired, for responses too big to read at once
+func (c *Cache) Open(key string) (io.ReadCloser, bool) {
+	path, ok := c.lookup(key)
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	return f, true
+}
+
+// Finds the entry for key, removing it when it has expired so the directory does not keep growing
+func (c *Cache) lookup(key string) (string, bool) {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	if time.Since(info.ModTime()) > c.TTL {
+		os.Remove(path)
+		return "", false
+	}
+	return path, true
+}
+
+// Entry - A cache entry being written, only visible under its key once committed
+type entry struct {
+	*os.File
+	path string
+}
+
+// Starts writing the entry for key into a temp file next to it
+func (c *Cache) create(key string) (*entry, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".entry-*")
+	if err != nil {
+		return nil, err
+	}
+	return &entry{File: tmp, path: c.path(key)}, nil
+}
+
+// Moves the finished entry under its key
+func (e *entry) commit() error {
+	if err := e.Close(); err != nil {
+		os.Remove(e.Name())
+		return err
+	}
+	return os.Rename(e.Name(), e.path)
+}
+
+// Throws away an entry that will not be completed
+func (e *entry) abort() {
+	e.Close()
+	os.Remove(e.Name())
+}
+
+// Clear removes every entry and returns how many were removed
+func (c *Cache) Clear() (int, error) {
+	entries, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if err := os.Remove(entry); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Reports whether the response to req can be kept. On top of Cacheable, the window must have ended at least
+// one TTL ago: data for a window ending around now (e.g. an end time of now, or every --watch poll) is still
+// arriving, and its entry would only be read again by a rerun of the very same window.
+func (c *Cache) cacheable(req Request, now time.Time) bool {
+	return Cacheable(req, now.Add(-c.TTL))
+}
+
+// Skip counts a query that was not cacheable
+func (c *Cache) skip() {
+	c.skipped.Add(1)
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// CacheKey hashes everything that decides a response: the endpoint, the token (so orgs never
+// share entries), the query with whitespace collapsed, and the variables
+func CacheKey(endpoint, token string, req Request) (string, error) {
+	tokenSum := sha256.Sum256([]byte(token))
+	b, err := json.Marshal(struct {
+		Endpoint  string    `json:"endpoint"`
+		Token     string    `json:"token"`
+		Query     string    `json:"query"`
+		Variables Variables `json:"variables"`
+	}{endpoint, hex.EncodeToString(tokenSum[:]), strings.Join(strings.Fields(req.Query), " "), req.Variables})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Cacheable reports whether the response to req can be reused: mutations never are, and neither
+// is a query without an endTime variable, e.g. a group's vehicles or sites, which change at any time.
+// A window whose endTime is still in the future is not either, as more data will arrive.
+func Cacheable(req Request, now time.Time) bool {
+	if !idempotent(req.Query) {
+		return false
+	}
+	endTime, ok := req.Variables["endTime"]
+	if !ok {
+		return false
+	}
+	var endMs int64
+	switch v := endTime.(type) {
+	case int:
+		endMs = int64(v)
+	case int64:
+		endMs = v
+	case float64:
+		endMs = int64(v)
+	default:
+		return false
+	}
+	return endMs <= now.UnixMilli()
+}
@@ -0,0 +1,114 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+	}))
+	defer server.Close()
+
+	cache := NewCache(t.TempDir(), time.Hour)
+	client := NewClient(Options{Endpoint: server.URL, Token: "secret", Cache: cache})
+	past := Request{Query: deviceQuery, Variables: Variables{"deviceId": 1, "endTime": 1541168971265}}
+	// Same query with different whitespace hits the same entry
+	pastReformatted := Request{Query: "query device($deviceId: Int64!) {\n  device(id: $deviceId) { name }\n}", Variables: past.Variables}
+
+	for _, req := range []Request{past, pastReformatted} {
+		result, err := Execute[deviceName](context.Background(), client, req)
+		if err != nil {
+			t.Fatalf("Received an error: %s", err)
+		}
+		if result.Device == nil || result.Device.Name != "Truck 1" {
+			t.Errorf("Did not decode the response, got: %+v", result.Device)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Cached query was fetched again, got: %d calls, want: 1", calls.Load())
+	}
+
+	// A window still open is never cached, and neither is one that ended within the TTL, e.g. a live window
+	future := Request{Query: deviceQuery, Variables: Variables{"deviceId": 1, "endTime": time.Now().Add(time.Hour).UnixMilli()}}
+	live := Request{Query: deviceQuery, Variables: Variables{"deviceId": 1, "endTime": time.Now().Add(-time.Minute).UnixMilli()}}
+	for _, req := range []Request{future, future, live, live} {
+		Execute[deviceName](context.Background(), client, req)
+	}
+	if calls.Load() != 5 {
+		t.Errorf("Recent window was cached, got: %d calls, want: 5", calls.Load())
+	}
+	if stats := cache.Stats(); stats != (CacheStats{Hits: 1, Misses: 1, Skipped: 4}) {
+		t.Errorf("Did not count cache use, got: %+v", stats)
+	}
+
+	// A different token never sees another org's entries
+	other := NewClient(Options{Endpoint: server.URL, Token: "other", Cache: cache})
+	Execute[deviceName](context.Background(), other, past)
+	if calls.Load() != 6 {
+		t.Errorf("Cache was shared across tokens, got: %d calls, want: 6", calls.Load())
+	}
+
+	removed, err := cache.Clear()
+	if err != nil || removed != 2 {
+		t.Errorf("Did not clear the cache, removed: %d, err: %v", removed, err)
+	}
+}
+
+func TestCacheSkipsErrorsAndExpires(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Write([]byte(`{"data": null, "errors": [{"message": "boom"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+	}))
+	defer server.Close()
+
+	cache := NewCache(t.TempDir(), time.Hour)
+	client := NewClient(Options{Endpoint: server.URL, Cache: cache})
+	req := Request{Query: deviceQuery, Variables: Variables{"deviceId": 1, "endTime": 1541168971265}}
+	if _, err := Execute[deviceName](context.Background(), client, req); err == nil {
+		t.Fatal("Expected the graphQL error")
+	}
+	if _, err := Execute[deviceName](context.Background(), client, req); err != nil {
+		t.Fatalf("Error response was cached: %s", err)
+	}
+
+	cache.TTL = 0
+	Execute[deviceName](context.Background(), client, req)
+	if calls.Load() != 3 {
+		t.Errorf("Expired entry was served, got: %d calls, want: 3", calls.Load())
+	}
+	// The expired entry is removed when found, only the one just written is left
+	if entries, _ := filepath.Glob(filepath.Join(cache.Dir, "*.json")); len(entries) != 1 {
+		t.Errorf("Expired entry was kept, got: %v", entries)
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	now := time.UnixMilli(1541168971265)
+	for _, tc := range []struct {
+		req  Request
+		want bool
+	}{
+		{Request{Query: deviceQuery, Variables: Variables{"endTime": 1541168971265}}, true},
+		{Request{Query: deviceQuery, Variables: Variables{"endTime": 1541168971266}}, false},
+		// Without a window the answer can change at any time, e.g. a group's vehicles or sites
+		{Request{Query: deviceQuery, Variables: Variables{"groupId": 4656}}, false},
+		{Request{Query: "mutation { rename }", Variables: Variables{"endTime": 1}}, false},
+	} {
+		if got := Cacheable(tc.req, now); got != tc.want {
+			t.Errorf("%+v: got %v, want %v", tc.req, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Chunking - How queries over long time windows are split up
+type Chunking struct {
+	Size        time.Duration // Longest window fetched by one query, zero fetches every window whole
+	Concurrency int           // Chunks fetched at once, 1 when zero or less
+}
+
+// Window - A time range in the form the API takes it, an end time and a duration in milliseconds
+type Window struct {
+	EndMs      int
+	DurationMs int
+}
+
+// StartMs is the start of the window
+func (w Window) StartMs() int {
+	return w.EndMs - w.DurationMs
+}
+
+// Split divides the window ending at endMs into chunks of at most Size, oldest first.
+// Neighbouring chunks share their boundary millisecond, as the API includes both ends of a window,
+// so anything at a boundary is fetched twice and has to be de-duplicated when merging.
+func (c Chunking) Split(endMs, durationMs int) []Window {
+	size := int(c.Size / time.Millisecond)
+	if size <= 0 || durationMs <= size {
+		return []Window{{EndMs: endMs, DurationMs: durationMs}}
+	}
+	var windows []Window
+	for start := endMs - durationMs; start < endMs; start += size {
+		end := min(start+size, endMs)
+		windows = append(windows, Window{EndMs: end, DurationMs: end - start})
+	}
+	return windows
+}
+
+// JoinChunks joins the items of consecutive chunks, e.g. trips, into what one query over the whole window
+// returns, given each item's start and end time and how to join an item to the next one. An item overlapping a
+// chunk boundary comes back in both chunks and is only kept once, and an item the API cut at a boundary (ending
+// exactly where the next chunk's item starts) is joined back together. Back-to-back items anywhere else stay apart.
+func JoinChunks[T any](windows []Window, chunks [][]T, span func(T) (startMs, endMs int), join func(first, next T) T) []T {
+	var merged []T
+	for c, chunk := range chunks {
+		for _, item := range chunk {
+			start, end := span(item)
+			last := len(merged) - 1
+			if last < 0 {
+				merged = append(merged, item)
+				continue
+			}
+			lastStart, lastEnd := span(merged[last])
+			switch {
+			case start == lastStart && end == lastEnd:
+				// The same item seen from both sides of a boundary
+			case c > 0 && start == lastEnd && lastEnd == windows[c].StartMs():
+				merged[last] = join(merged[last], item)
+			default:
+				merged = append(merged, item)
+			}
+		}
+	}
+	return merged
+}
+
+// FetchChunks splits the window ending at endMs with the client's Chunking and calls fetch for each chunk,
+// running up to Concurrency of them at once. Results are returned in chunk order, oldest first.
+// The first error cancels the chunks still running and is returned; on a partial response the results
+// are still returned with the first partial error, see IsPartial.
+func FetchChunks[T any](ctx context.Context, c *Client, endMs, durationMs int, fetch func(context.Context, Window) (T, error)) ([]T, error) {
+	windows := c.Chunking.Split(endMs, durationMs)
+	if len(windows) > 1 {
+		c.Logger.Debug("splitting query window", "chunks", len(windows), "concurrency", max(c.Chunking.Concurrency, 1), "size", c.Chunking.Size)
+	}
+	return fetchWindows(ctx, c, windows, fetch)
+}
+
+// Calls fetch for each window as FetchChunks does for its chunks, for callers that pick their own windows
+func fetchWindows[T any](ctx context.Context, c *Client, windows []Window, fetch func(context.Context, Window) (T, error)) ([]T, error) {
+	if len(windows) == 1 {
+		result, err := fetch(ctx, windows[0])
+		if err != nil && !IsPartial(err) {
+			return nil, err
+		}
+		return []T{result}, err
+	}
+	concurrency := max(c.Chunking.Concurrency, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]T, len(windows))
+	slots := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var failed, partial error
+	var wg sync.WaitGroup
+	for i, w := range windows {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := fetch(ctx, w)
+			results[i] = result
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+			case IsPartial(err):
+				if partial == nil {
+					partial = err
+				}
+			case failed == nil:
+				// Later errors are the cancellations this causes, so only the first is kept
+				failed = err
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return results, partial
+}
@@ -0,0 +1,118 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChunkingSplit(t *testing.T) {
+	chunking := Chunking{Size: time.Hour}
+	hour := int(time.Hour / time.Millisecond)
+	half := hour / 2
+
+	// Short windows are fetched whole
+	if got := chunking.Split(10*hour, hour); !reflect.DeepEqual(got, []Window{{10 * hour, hour}}) {
+		t.Errorf("Split a window that fits, got: %v", got)
+	}
+	// Long windows are split oldest first, the last chunk taking the remainder
+	got := chunking.Split(10*hour, 2*hour+half)
+	want := []Window{{8*hour + half, hour}, {9*hour + half, hour}, {10 * hour, half}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong chunks, got: %v, want: %v", got, want)
+	}
+	if got[0].StartMs() != 7*hour+half {
+		t.Errorf("First chunk does not start at the window start, got: %d", got[0].StartMs())
+	}
+	// No size means no chunking
+	if got := (Chunking{}).Split(10*hour, 5*hour); len(got) != 1 {
+		t.Errorf("Split without a size, got: %v", got)
+	}
+}
+
+func TestFetchChunks(t *testing.T) {
+	client := NewClient(Options{Chunking: Chunking{Size: 10 * time.Millisecond, Concurrency: 2}})
+
+	// Results come back in chunk order, with no more than Concurrency running at once
+	var running, most atomic.Int32
+	results, err := FetchChunks(context.Background(), client, 100, 50, func(ctx context.Context, w Window) (int, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := most.Load()
+			if n <= m || most.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return w.StartMs(), nil
+	})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if want := []int{50, 60, 70, 80, 90}; !reflect.DeepEqual(results, want) {
+		t.Errorf("Wrong results, got: %v, want: %v", results, want)
+	}
+	if most.Load() > 2 {
+		t.Errorf("Ran %d chunks at once, want at most 2", most.Load())
+	}
+
+	// A failed chunk cancels the rest and its error is returned, not the cancellations
+	failure := errors.New("chunk failed")
+	_, err = FetchChunks(context.Background(), client, 100, 50, func(ctx context.Context, w Window) (int, error) {
+		if w.StartMs() == 50 {
+			return 0, failure
+		}
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Expected the chunk error, got: %v", err)
+	}
+
+	// Partial chunks still return every result
+	results, err = FetchChunks(context.Background(), client, 100, 20, func(ctx context.Context, w Window) (int, error) {
+		if w.StartMs() == 80 {
+			return 1, &ResponseError{Errors: []Error{{Message: "one device failed"}}, Partial: true}
+		}
+		return 2, nil
+	})
+	if !IsPartial(err) || !reflect.DeepEqual(results, []int{1, 2}) {
+		t.Errorf("Expected partial results, got: %v, %v", results, err)
+	}
+}
+
+func TestJoinChunks(t *testing.T) {
+	span := func(w Window) (int, int) { return w.StartMs(), w.EndMs }
+	join := func(first, next Window) Window {
+		return Window{EndMs: next.EndMs, DurationMs: next.EndMs - first.StartMs()}
+	}
+	items := func(spans ...[2]int) []Window {
+		var ws []Window
+		for _, s := range spans {
+			ws = append(ws, Window{EndMs: s[1], DurationMs: s[1] - s[0]})
+		}
+		return ws
+	}
+	// The same item from two chunks, one cut at the chunk boundary 5000, and back-to-back items at 2000 and
+	// 7500 that are not at a boundary and stay apart
+	windows := []Window{{EndMs: 5000, DurationMs: 4000}, {EndMs: 9000, DurationMs: 4000}}
+	joined := JoinChunks(windows, [][]Window{
+		items([2]int{1000, 2000}, [2]int{2000, 3000}, [2]int{4000, 5000}, [2]int{4500, 5500}),
+		items([2]int{4500, 5500}, [2]int{5000, 6000}, [2]int{7000, 7500}, [2]int{7500, 8000}),
+	}, span, join)
+	want := items([2]int{1000, 2000}, [2]int{2000, 3000}, [2]int{4000, 5000}, [2]int{4500, 5500}, [2]int{5000, 6000}, [2]int{7000, 7500}, [2]int{7500, 8000})
+	if !reflect.DeepEqual(joined, want) {
+		t.Errorf("got %+v, want %+v", joined, want)
+	}
+	joined = JoinChunks(windows, [][]Window{items([2]int{4000, 5000}), items([2]int{5000, 6000})}, span, join)
+	if want := items([2]int{4000, 6000}); !reflect.DeepEqual(joined, want) {
+		t.Errorf("got %+v, want %+v", joined, want)
+	}
+	if joined := JoinChunks(windows, [][]Window{nil, nil}, span, join); joined != nil {
+		t.Errorf("Expected nothing from empty chunks, got %+v", joined)
+	}
+}
@@ -0,0 +1,285 @@
+// Package graphql is the Samsara GraphQL client shared by the OwlCode tools.
+// It owns the endpoint, the access token and a single pooled http.Client so
+// that every query made by a tool goes through the same connection pool.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultEndpoint is used when no endpoint is configured
+const DefaultEndpoint = "https://api.samsara.com/v1/admin/graphql"
+
+// Options - Settings used to build a Client
+type Options struct {
+	Endpoint  string          // GraphQL URL, DefaultEndpoint when empty
+	Token     string          // Samsara access token
+	Timeout   time.Duration   // HTTP timeout for a single request
+	Retry     RetryPolicy     // Retry rules for queries, DefaultRetryPolicy for zero fields
+	Limits    RateLimits      // Client side rate limits, DefaultRateLimit for zero fields
+	Record    string          // Directory to save every request/response pair to, see RecordTransport
+	Replay    string          // Directory to answer requests from instead of the network, see ReplayTransport
+	Cache     *Cache          // Optional on-disk response cache, unused when recording or replaying
+	Chunking  Chunking        // How long windows are split up by FetchChunks
+	Batching  Batching        // How ExecuteBatch packs items into documents, DefaultBatching for zero fields
+	Transport *http.Transport // Base transport with proxy and TLS settings from NewTransport, a pooled default transport when nil
+	Logger    *slog.Logger    // Receives a debug record per request and an info record per retry, nothing logged when nil
+}
+
+// Client - Holds the endpoint, token and pooled http.Client used for every query
+type Client struct {
+	Endpoint string
+	Token    string
+	HTTP     *http.Client
+	Retry    RetryPolicy
+	Limiter  *Limiter // Shared with every other client of the same endpoint
+	Cache    *Cache   // Nil when caching is off
+	Chunking Chunking
+	Batching Batching
+	Logger   *slog.Logger
+
+	// OnRetry is called before waiting to retry a failed attempt, e.g. for verbose output
+	OnRetry func(attempt int, err error, wait time.Duration)
+
+	requests atomic.Int64
+	retries  atomic.Int64
+	limited  atomic.Int64 // Nanoseconds spent waiting on the rate limiter
+
+	batchMu    sync.Mutex
+	batchBytes map[string]int // Average response bytes per item of each Batch, by operation name
+}
+
+// Stats - Counters of the requests a Client has made
+type Stats struct {
+	Requests int64         // HTTP requests sent, retries included
+	Retries  int64         // Attempts that failed and were tried again
+	Limited  time.Duration // Total time requests waited on the rate limiter
+}
+
+// Variables - Values for the $variables declared by a query document
+type Variables map[string]interface{}
+
+// Request - Used to marshal a graphQL query and its variables
+type Request struct {
+	Query     string    `json:"query"`
+	Variables Variables `json:"variables,omitempty"`
+}
+
+// NewClient creates a Client whose http.Client keeps idle connections to the endpoint open between queries
+func NewClient(opts Options) *Client {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	pooled := opts.Transport
+	if pooled == nil {
+		pooled, _ = NewTransport(Network{})
+	}
+	var transport http.RoundTripper = pooled
+	limiter := LimiterFor(endpoint, opts.Limits)
+	cache := opts.Cache
+	if opts.Record != "" || opts.Replay != "" {
+		// Cache hits would leave requests out of a recording, and replays are already local
+		cache = nil
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	switch {
+	case opts.Replay != "":
+		// Nothing goes over the network, so there is nothing to rate limit
+		transport = ReplayTransport(opts.Replay)
+		limiter = NewLimiter(RateLimit{RequestsPerSecond: -1})
+	case opts.Record != "":
+		transport = RecordTransport(opts.Record, pooled)
+	}
+	return &Client{
+		Endpoint: endpoint,
+		Token:    opts.Token,
+		HTTP:     &http.Client{Transport: transport, Timeout: opts.Timeout},
+		Retry:    opts.Retry.withDefaults(),
+		Limiter:  limiter,
+		Cache:    cache,
+		Chunking: opts.Chunking,
+		Batching: opts.Batching,
+		Logger:   logger,
+	}
+}
+
+// Stats returns the request and retry counts and rate limit wait so far
+func (c *Client) Stats() Stats {
+	return Stats{Requests: c.requests.Load(), Retries: c.retries.Load(), Limited: time.Duration(c.limited.Load())}
+}
+
+// Do sends req to the endpoint and unmarshals the response data into out.
+// Queries are retried on throttling, gateway errors and dropped connections as set by the client's RetryPolicy.
+// Cancelling ctx aborts the request in flight and any wait for a retry or the rate limiter.
+func (c *Client) Do(ctx context.Context, req Request, out interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshalling query: %w", err)
+	}
+	policy := c.Retry.withDefaults()
+	maxAttempts := policy.MaxAttempts
+	if !idempotent(req.Query) {
+		maxAttempts = 1
+	}
+	name := operationName(req.Query)
+	cacheKey, err := c.cacheKey(req)
+	if err != nil {
+		return err
+	}
+	if cacheKey != "" {
+		if body, ok := c.Cache.Get(cacheKey); ok {
+			c.Logger.Debug("graphql cache hit", "query", name, "bytes", len(body))
+			return decodeResponse(body, out)
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		body, retryAfter, err := c.post(ctx, name, attempt, b)
+		if err == nil {
+			err = decodeResponse(body, out)
+			// Only complete, error free responses are worth keeping. The cache is best effort,
+			// a failed write just means the next run fetches again.
+			if err == nil && cacheKey != "" {
+				c.Cache.Put(cacheKey, body)
+			}
+			return err
+		}
+		if err := c.retry(ctx, policy, maxAttempts, name, attempt, err, retryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+// Decides whether a failed attempt is tried again, and if so waits out the backoff.
+// Returns nil to try again, or the error to give up with.
+func (c *Client) retry(ctx context.Context, policy RetryPolicy, maxAttempts int, name string, attempt int, err error, retryAfter time.Duration) error {
+	if attempt >= maxAttempts || !retryable(err) || ctx.Err() != nil {
+		if attempt > 1 {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		return err
+	}
+	wait := policy.Backoff(attempt, retryAfter)
+	c.retries.Add(1)
+	c.Logger.Info("retrying graphql request", "query", name, "attempt", attempt, "wait", wait.Round(time.Millisecond), "err", err)
+	if c.OnRetry != nil {
+		c.OnRetry(attempt, err, wait)
+	}
+	return sleep(ctx, wait)
+}
+
+// The cache key for req, empty when there is no cache or req is not cacheable
+func (c *Client) cacheKey(req Request) (string, error) {
+	if c.Cache == nil {
+		return "", nil
+	}
+	if !c.Cache.cacheable(req, time.Now()) {
+		c.Cache.skip()
+		return "", nil
+	}
+	return CacheKey(c.Endpoint, c.Token, req)
+}
+
+// Makes a single POST of the marshalled request, returning the body of a 200 response.
+// For other statuses the Retry-After the server asked for is returned with the error.
+// Each attempt that reaches the server is logged at debug level with its timing, status and size.
+func (c *Client) post(ctx context.Context, name string, attempt int, b []byte) ([]byte, time.Duration, error) {
+	resp, start, retryAfter, err := c.send(ctx, name, attempt, b)
+	if err != nil {
+		return nil, retryAfter, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	c.Logger.Debug("graphql request", "query", name, "attempt", attempt, "duration", time.Since(start), "status", resp.StatusCode, "bytes", len(body))
+	if err != nil {
+		return nil, 0, &transportError{"reading response", err}
+	}
+	return body, 0, nil
+}
+
+// Sends the marshalled request once the rate limiter allows, returning the unread 200 response and when it was sent.
+// Failed attempts are logged here, the caller logs a 200 once it has read the body.
+func (c *Client) send(ctx context.Context, name string, attempt int, b []byte) (*http.Response, time.Time, time.Duration, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("generating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Access-Token", c.Token)
+
+	waited, err := c.Limiter.Wait(ctx)
+	c.limited.Add(int64(waited))
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	c.requests.Add(1)
+	start := time.Now()
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		c.Logger.Debug("graphql request", "query", name, "attempt", attempt, "duration", time.Since(start), "err", err)
+		return nil, start, 0, &transportError{"getting response", err}
+	}
+	// Check if we get any page errors, this is not caught by err
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		c.Logger.Debug("graphql request", "query", name, "attempt", attempt, "duration", time.Since(start), "status", resp.StatusCode, "bytes", len(snippet))
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return nil, start, retryAfter, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(snippet))}
+	}
+	return resp, start, 0, nil
+}
+
+// Response - The envelope every graphQL response is wrapped in
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []Error         `json:"errors"`
+}
+
+// Decodes the data of a response into out, and reports any errors it carried.
+// When there are both data and errors the data is still decoded and a partial ResponseError is returned.
+func decodeResponse(body []byte, out interface{}) error {
+	var env response
+	if err := json.Unmarshal(body, &env); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	hasData := len(env.Data) > 0 && string(env.Data) != "null"
+	if hasData {
+		if err := json.Unmarshal(env.Data, out); err != nil {
+			return fmt.Errorf("decoding response data: %w", err)
+		}
+	}
+	if len(env.Errors) > 0 {
+		return &ResponseError{Errors: env.Errors, Partial: hasData}
+	}
+	if !hasData {
+		return errors.New("response contained no data")
+	}
+	return nil
+}
+
+// Execute runs req and returns the response data decoded into a T.
+// On a partial response the decoded data is returned together with the error, see IsPartial.
+func Execute[T any](ctx context.Context, c *Client, req Request) (T, error) {
+	var data T
+	err := c.Do(ctx, req, &data)
+	if err != nil && !IsPartial(err) {
+		var zero T
+		return zero, err
+	}
+	return data, err
+}
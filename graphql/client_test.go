@@ -0,0 +1,135 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const deviceQuery = `query device($deviceId: Int64!) { device(id: $deviceId) { name } }`
+
+func TestExecute(t *testing.T) {
+	// Stand in for the graphQL endpoint, checking the token and query are sent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Access-Token") != "secret" {
+			t.Errorf("Did not send the access token, got: %q", r.Header.Get("X-Access-Token"))
+		}
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Could not decode request: %s", err)
+		}
+		if req.Query != deviceQuery {
+			t.Errorf("Did not send the query, got: %q", req.Query)
+		}
+		// Numbers come back as float64 from an untyped decode
+		if req.Variables["deviceId"] != float64(212014918236538) {
+			t.Errorf("Did not send the variables, got: %v", req.Variables)
+		}
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Token: "secret", Timeout: time.Second})
+	result, err := Execute[struct {
+		Device struct{ Name string }
+	}](context.Background(), client, Request{Query: deviceQuery, Variables: Variables{"deviceId": 212014918236538}})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if result.Device.Name != "Truck 1" {
+		t.Errorf("Did not decode the response, got: %q, want: %q", result.Device.Name, "Truck 1")
+	}
+}
+
+func TestExecutePageError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL})
+	_, err := Execute[struct{}](context.Background(), client, Request{Query: "{}"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401 StatusError, got: %v", err)
+	}
+}
+
+func TestNewClientDefaultEndpoint(t *testing.T) {
+	client := NewClient(Options{})
+	if client.Endpoint != DefaultEndpoint {
+		t.Errorf("Did not default the endpoint, got: %s, want: %s", client.Endpoint, DefaultEndpoint)
+	}
+}
+
+func TestRequestMarshal(t *testing.T) {
+	// Variables are left out entirely when a query has none
+	b, err := json.Marshal(Request{Query: "{ me { name } }"})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	expected := `{"query":"{ me { name } }"}`
+	if string(b) != expected {
+		t.Errorf("Did not marshal the request, got: %s, want: %s", b, expected)
+	}
+}
+
+func TestExecuteCancel(t *testing.T) {
+	// A hung server must not hold the caller once the context is cancelled
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(Options{Endpoint: server.URL})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := Execute[struct{}](ctx, client, Request{Query: "{}"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a deadline error, got: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Cancelled request took %s to return", time.Since(start))
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	// Each request is logged at debug level with the query name, status and response size
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewClient(Options{Endpoint: server.URL, Logger: logger})
+	if _, err := Execute[struct{}](context.Background(), client, Request{Query: deviceQuery}); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	var record struct {
+		Msg      string
+		Query    string
+		Attempt  int
+		Status   int
+		Bytes    int
+		Duration int64
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("Could not decode the log record %q: %s", logs.String(), err)
+	}
+	if record.Msg != "graphql request" || record.Query != "device" || record.Attempt != 1 || record.Status != 200 || record.Bytes != 41 {
+		t.Errorf("Did not log the request fields, got: %s", logs.String())
+	}
+}
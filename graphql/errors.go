@@ -0,0 +1,88 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Location - Line and column of the query document an error refers to
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error - A single entry of the "errors" array of a graphQL response
+type Error struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"` // Field names and list indexes
+	Locations  []Location             `json:"locations,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// PathString joins the error path as it would be written in a query, e.g. group.devices.3.name
+func (e Error) PathString() string {
+	parts := make([]string, 0, len(e.Path))
+	for _, p := range e.Path {
+		switch v := p.(type) {
+		case string:
+			parts = append(parts, v)
+		case float64:
+			parts = append(parts, strconv.Itoa(int(v)))
+		default:
+			parts = append(parts, fmt.Sprint(v))
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func (e Error) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	return e.PathString() + ": " + e.Message
+}
+
+// ResponseError - Returned when a response carries an "errors" array.
+// Partial is true when the response also had data, which has then been decoded into the result.
+type ResponseError struct {
+	Errors  []Error
+	Partial bool
+}
+
+func (e *ResponseError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, gqlErr := range e.Errors {
+		msgs[i] = gqlErr.Error()
+	}
+	var prefix string
+	switch {
+	case e.Partial:
+		prefix = "graphql partial data"
+	case len(msgs) > 1:
+		prefix = "graphql errors"
+	default:
+		prefix = "graphql error"
+	}
+	return prefix + ": " + strings.Join(msgs, "; ")
+}
+
+// StatusError - Returned when the endpoint answers with a non-200 status
+type StatusError struct {
+	StatusCode int
+	Body       string // Start of the response body, to help tell a bad token from a bad query
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return "Page error:" + strconv.Itoa(e.StatusCode)
+	}
+	return "Page error:" + strconv.Itoa(e.StatusCode) + ": " + e.Body
+}
+
+// IsPartial reports whether err only means some fields errored while the rest of the data was decoded
+func IsPartial(err error) bool {
+	var respErr *ResponseError
+	return errors.As(err, &respErr) && respErr.Partial
+}
@@ -0,0 +1,75 @@
+package graphql
+
+import (
+	"strings"
+	"testing"
+)
+
+type deviceName struct {
+	Device *struct{ Name string }
+}
+
+func TestDecodeResponseErrors(t *testing.T) {
+	// A bad field comes back as a 200 with no data, which must not look like an empty result
+	body := `{"data": null, "errors": [{"message": "Cannot query field \"nmae\"", "path": ["device", "nmae"]}]}`
+	var out deviceName
+	err := decodeResponse([]byte(body), &out)
+	respErr, ok := err.(*ResponseError)
+	if !ok {
+		t.Fatalf("Expected a ResponseError, got: %v", err)
+	}
+	if respErr.Partial {
+		t.Errorf("Response without data reported as partial")
+	}
+	if len(respErr.Errors) != 1 || respErr.Errors[0].PathString() != "device.nmae" {
+		t.Errorf("Did not decode the error path, got: %v", respErr.Errors)
+	}
+	if !strings.Contains(err.Error(), "device.nmae: Cannot query field") {
+		t.Errorf("Error message missing path and message, got: %s", err)
+	}
+}
+
+func TestDecodeResponsePartial(t *testing.T) {
+	// One device failing should still hand back the devices that worked
+	body := `{"data": {"device": {"name": "Truck 1"}}, "errors": [{"message": "timeout", "path": ["device", "objectStat", 2]}]}`
+	var out deviceName
+	err := decodeResponse([]byte(body), &out)
+	if !IsPartial(err) {
+		t.Fatalf("Expected a partial error, got: %v", err)
+	}
+	if out.Device == nil || out.Device.Name != "Truck 1" {
+		t.Errorf("Partial data was not decoded, got: %+v", out.Device)
+	}
+	if !strings.Contains(err.Error(), "device.objectStat.2: timeout") {
+		t.Errorf("Error message missing indexed path, got: %s", err)
+	}
+}
+
+func TestDecodeResponseMalformed(t *testing.T) {
+	var out deviceName
+	if err := decodeResponse([]byte(`<html>Bad Gateway</html>`), &out); err == nil {
+		t.Errorf("Expected an error for a body that is not JSON")
+	}
+	if err := decodeResponse([]byte(`{"data": {"device": {"name": 5}}}`), &out); err == nil {
+		t.Errorf("Expected an error for data of the wrong type")
+	}
+	if err := decodeResponse([]byte(`{}`), &out); err == nil {
+		t.Errorf("Expected an error for an empty response")
+	}
+}
+
+func TestResponseErrorMessage(t *testing.T) {
+	two := []Error{{Message: "a"}, {Message: "b"}}
+	for _, tc := range []struct {
+		err  ResponseError
+		want string
+	}{
+		{ResponseError{Errors: two[:1]}, "graphql error: a"},
+		{ResponseError{Errors: two}, "graphql errors: a; b"},
+		{ResponseError{Errors: two, Partial: true}, "graphql partial data: a; b"},
+	} {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
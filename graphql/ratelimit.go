@@ -0,0 +1,132 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit - Token bucket settings. Zero fields use DefaultRateLimit and a negative
+// RequestsPerSecond turns limiting off.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// RateLimits - The limit for every endpoint plus overrides for particular endpoint URLs
+type RateLimits struct {
+	Default   RateLimit
+	Endpoints map[string]RateLimit
+}
+
+// DefaultRateLimit keeps a process well under the API's throttling threshold
+var DefaultRateLimit = RateLimit{RequestsPerSecond: 5, Burst: 10}
+
+// Limiter - Token bucket shared by every request a process sends to one endpoint
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens added per second, 0 for unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Process wide limiters, so clients built for the same endpoint with the same limit draw from the same bucket
+var (
+	limitersMu sync.Mutex
+	limiters   = map[limiterKey]*Limiter{}
+)
+
+type limiterKey struct {
+	endpoint string
+	limit    RateLimit
+}
+
+// NewLimiter creates a full token bucket, a non-positive rate makes a limiter that never waits
+func NewLimiter(limit RateLimit) *Limiter {
+	l := &Limiter{last: time.Now()}
+	if limit.RequestsPerSecond > 0 {
+		l.rate = limit.RequestsPerSecond
+		l.burst = float64(limit.Burst)
+		if l.burst < 1 {
+			l.burst = 1
+		}
+		l.tokens = l.burst
+	}
+	return l
+}
+
+// LimiterFor returns the process wide limiter for endpoint at the limit limits give it, creating it on first use.
+// A client asking for a different limit on the same endpoint, e.g. a test turning limiting off, gets its own bucket.
+func LimiterFor(endpoint string, limits RateLimits) *Limiter {
+	limit, ok := limits.Endpoints[endpoint]
+	if !ok {
+		limit = limits.Default
+	}
+	key := limiterKey{endpoint, limit.withDefaults()}
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	if l, ok := limiters[key]; ok {
+		return l
+	}
+	l := NewLimiter(key.limit)
+	limiters[key] = l
+	return l
+}
+
+// Fills zero fields with DefaultRateLimit
+func (r RateLimit) withDefaults() RateLimit {
+	if r.RequestsPerSecond == 0 {
+		r.RequestsPerSecond = DefaultRateLimit.RequestsPerSecond
+	}
+	if r.Burst <= 0 {
+		r.Burst = DefaultRateLimit.Burst
+	}
+	return r
+}
+
+// Reserve takes a token and returns how long the caller must wait before using it.
+// Tokens may go negative so concurrent callers queue up in the order they reserved.
+func (l *Limiter) Reserve() time.Duration {
+	if l == nil || l.rate == 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until a token is available or ctx is done, and returns how long it waited
+func (l *Limiter) Wait(ctx context.Context) (time.Duration, error) {
+	wait := l.Reserve()
+	if wait <= 0 {
+		return 0, ctx.Err()
+	}
+	start := time.Now()
+	if err := sleep(ctx, wait); err != nil {
+		return time.Since(start), err
+	}
+	return wait, nil
+}
+
+// Sleeps for d, returning early with the context's error if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,54 @@
+package graphql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	// The burst goes through at once, after that callers queue at the refill rate
+	l := NewLimiter(RateLimit{RequestsPerSecond: 10, Burst: 2})
+	for i := 0; i < 2; i++ {
+		if wait := l.Reserve(); wait != 0 {
+			t.Errorf("Burst request %d had to wait %s", i, wait)
+		}
+	}
+	third, fourth := l.Reserve(), l.Reserve()
+	if third < 90*time.Millisecond || third > 100*time.Millisecond {
+		t.Errorf("Third request wait out of range, got: %s, want: ~100ms", third)
+	}
+	if fourth < 190*time.Millisecond || fourth > 200*time.Millisecond {
+		t.Errorf("Fourth request wait out of range, got: %s, want: ~200ms", fourth)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := NewLimiter(RateLimit{RequestsPerSecond: -1})
+	for i := 0; i < 100; i++ {
+		if wait := l.Reserve(); wait != 0 {
+			t.Fatalf("Unlimited limiter waited %s", wait)
+		}
+	}
+}
+
+func TestLimiterFor(t *testing.T) {
+	limits := RateLimits{
+		Default:   RateLimit{RequestsPerSecond: 1, Burst: 1},
+		Endpoints: map[string]RateLimit{"http://staging.test/graphql": {RequestsPerSecond: -1}},
+	}
+	// Every client of an endpoint shares one bucket
+	a := LimiterFor("http://prod.test/graphql", limits)
+	if b := LimiterFor("http://prod.test/graphql", limits); a != b {
+		t.Errorf("Clients of the same endpoint did not share a limiter")
+	}
+	if a.rate != 1 {
+		t.Errorf("Did not use the default limit, got rate: %v", a.rate)
+	}
+	if staging := LimiterFor("http://staging.test/graphql", limits); staging.rate != 0 {
+		t.Errorf("Did not use the endpoint override, got rate: %v", staging.rate)
+	}
+	// A different limit for the same endpoint is honoured rather than dropped for the first one
+	if off := LimiterFor("http://prod.test/graphql", RateLimits{Default: RateLimit{RequestsPerSecond: -1}}); off == a || off.rate != 0 {
+		t.Errorf("Did not honour a later limit for the endpoint, got rate: %v", off.rate)
+	}
+}
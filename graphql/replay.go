@@ -0,0 +1,207 @@
+package graphql
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrNotRecorded is returned when replaying a request that was never recorded. It is not retried.
+var ErrNotRecorded = errors.New("no recorded response")
+
+// Exchange - One recorded request/response pair, stored as a JSON file. The access
+// token is never written, so recordings can be shared with teammates.
+type Exchange struct {
+	Request  Request         `json:"request"`
+	Status   int             `json:"status"`
+	Header   http.Header     `json:"header,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`     // Response body when it is JSON
+	BodyText string          `json:"bodyText,omitempty"` // Response body when it is not
+}
+
+// RecordTransport returns a RoundTripper that sends requests through base and saves
+// every exchange to dir. A request sent more than once keeps its latest response.
+func RecordTransport(dir string, base http.RoundTripper) http.RoundTripper {
+	return &recorder{dir: dir, base: base}
+}
+
+// ReplayTransport returns a RoundTripper that answers requests from the exchanges
+// saved in dir by RecordTransport, without touching the network
+func ReplayTransport(dir string) http.RoundTripper {
+	return &replayer{dir: dir}
+}
+
+type recorder struct {
+	dir  string
+	base http.RoundTripper
+}
+
+func (r *recorder) RoundTrip(httpReq *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.base.RoundTrip(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req Request
+	if err := json.Unmarshal(reqBody, &req); err != nil {
+		return nil, fmt.Errorf("recording request: %w", err)
+	}
+	ex := Exchange{Request: req, Status: resp.StatusCode, Header: recordedHeader(resp.Header)}
+	if json.Valid(body) {
+		ex.Body = body
+	} else {
+		ex.BodyText = string(body)
+	}
+	if err := r.save(ex); err != nil {
+		return nil, fmt.Errorf("recording response: %w", err)
+	}
+	return resp, nil
+}
+
+// Writes the exchange through a temp file so concurrent identical requests never leave a torn file
+func (r *recorder) save(ex Exchange) error {
+	path, err := ExchangePath(r.dir, ex.Request)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(r.dir, ".exchange-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type replayer struct {
+	dir string
+}
+
+func (r *replayer) RoundTrip(httpReq *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	var req Request
+	if err := json.Unmarshal(reqBody, &req); err != nil {
+		return nil, fmt.Errorf("replaying request: %w", err)
+	}
+	path, err := ExchangePath(r.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s query in %s: %v", ErrNotRecorded, operationName(req.Query), r.dir, err)
+	}
+	var ex Exchange
+	if err := json.Unmarshal(b, &ex); err != nil {
+		return nil, fmt.Errorf("reading recording %s: %w", path, err)
+	}
+	body := []byte(ex.Body)
+	if ex.BodyText != "" {
+		body = []byte(ex.BodyText)
+	}
+	header := ex.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+		StatusCode:    ex.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       httpReq,
+	}, nil
+}
+
+// ExchangePath returns the file an exchange for req is stored in: the operation name plus
+// a hash of the query and variables, e.g. recording-3f2a9c0d1b7e.json
+func ExchangePath(dir string, req Request) (string, error) {
+	key, err := RequestKey(req)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, operationName(req.Query)+"-"+key[:12]+".json"), nil
+}
+
+// RequestKey hashes the query and variables. Variables are re-marshalled so their key order does not matter.
+func RequestKey(req Request) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var operationPattern = regexp.MustCompile(`^\s*(?:query|mutation)\s+(\w+)`)
+
+// Name of the operation in a query document, "query" for anonymous ones
+func operationName(query string) string {
+	if m := operationPattern.FindStringSubmatch(query); m != nil {
+		return m[1]
+	}
+	return "query"
+}
+
+// Reads the request body and puts it back so the request can still be sent
+func readRequestBody(httpReq *http.Request) ([]byte, error) {
+	if httpReq.Body == nil {
+		return nil, nil
+	}
+	b, err := io.ReadAll(httpReq.Body)
+	httpReq.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Body = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// Keeps only the response headers the client acts on
+func recordedHeader(h http.Header) http.Header {
+	out := http.Header{}
+	for _, name := range []string{"Content-Type", "Retry-After"} {
+		if v := h.Values(name); len(v) > 0 {
+			out[name] = v
+		}
+	}
+	return out
+}
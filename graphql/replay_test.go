@@ -0,0 +1,64 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+	}))
+	req := Request{Query: deviceQuery, Variables: Variables{"deviceId": 212014918236538}}
+
+	// Record a live exchange
+	recording := NewClient(Options{Endpoint: server.URL, Token: "secret", Record: dir})
+	if _, err := Execute[deviceName](context.Background(), recording, req); err != nil {
+		t.Fatalf("Received an error while recording: %s", err)
+	}
+	server.Close()
+
+	path, _ := ExchangePath(dir, req)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Exchange was not saved: %s", err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Errorf("Recording leaked the access token")
+	}
+	if filepath.Base(path)[:7] != "device-" {
+		t.Errorf("Recording not named after the operation, got: %s", filepath.Base(path))
+	}
+
+	// Replay it with the server gone and no token
+	replaying := NewClient(Options{Endpoint: server.URL, Replay: dir})
+	result, err := Execute[deviceName](context.Background(), replaying, req)
+	if err != nil {
+		t.Fatalf("Received an error while replaying: %s", err)
+	}
+	if result.Device == nil || result.Device.Name != "Truck 1" {
+		t.Errorf("Did not replay the response, got: %+v", result.Device)
+	}
+
+	// Different variables were never recorded
+	req.Variables["deviceId"] = 1
+	if _, err := Execute[deviceName](context.Background(), replaying, req); err == nil || !strings.Contains(err.Error(), "no recorded response for device query") {
+		t.Errorf("Expected a missing recording error, got: %v", err)
+	}
+}
+
+func TestRequestKeyOrder(t *testing.T) {
+	// Variable order must not change the key, so replays match
+	a, _ := RequestKey(Request{Query: "q", Variables: Variables{"a": 1, "b": 2}})
+	b, _ := RequestKey(Request{Query: "q", Variables: Variables{"b": 2, "a": 1}})
+	if a != b {
+		t.Errorf("Variable order changed the key")
+	}
+}
@@ -0,0 +1,105 @@
+package graphql
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy - Rules for retrying a query that failed with a transient error
+type RetryPolicy struct {
+	MaxAttempts int           // Total tries including the first one, 1 turns retries off
+	BaseDelay   time.Duration // Backoff before the first retry, doubled for every retry after
+	MaxDelay    time.Duration // Cap on the backoff between two tries
+}
+
+// DefaultRetryPolicy is used for any RetryPolicy field left at zero
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// Fills zero fields with DefaultRetryPolicy
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return p
+}
+
+// Backoff returns how long to wait after the given failed attempt (starting at 1).
+// The exponential delay is jittered between half and all of its value, and a longer
+// Retry-After from the server always wins.
+func (p RetryPolicy) Backoff(attempt int, retryAfter time.Duration) time.Duration {
+	p = p.withDefaults()
+	delay := p.MaxDelay
+	if attempt-1 < 32 {
+		if d := p.BaseDelay << (attempt - 1); d > 0 && d < p.MaxDelay {
+			delay = d
+		}
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+// Reports whether err is worth trying again: throttling, gateway errors and dropped connections
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, ErrNotRecorded) {
+		return false
+	}
+	var transportErr *transportError
+	return errors.As(err, &transportErr)
+}
+
+// Reports whether a query document can be safely sent more than once
+func idempotent(query string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(query), "mutation")
+}
+
+// Parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// TransportError - Wraps failures to send a request or read its response, which are retried
+type transportError struct {
+	op  string
+	err error
+}
+
+func (e *transportError) Error() string { return e.op + ": " + e.err.Error() }
+
+func (e *transportError) Unwrap() error { return e.err }
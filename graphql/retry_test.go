@@ -0,0 +1,107 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Fast policy so tests do not sleep for real backoffs
+var testRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestRetryTransientStatus(t *testing.T) {
+	// Fail twice with throttling and gateway errors, then answer
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"data": {"device": {"name": "Truck 1"}}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Retry: testRetry})
+	retried := 0
+	client.OnRetry = func(attempt int, err error, wait time.Duration) { retried++ }
+	if _, err := Execute[deviceName](context.Background(), client, Request{Query: "{ device { name } }"}); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	stats := client.Stats()
+	if stats.Retries != 2 || stats.Requests != 3 || retried != 2 {
+		t.Errorf("Did not count retries, got: %+v and %d OnRetry calls", stats, retried)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Retry: testRetry})
+	if _, err := Execute[deviceName](context.Background(), client, Request{Query: "{ device { name } }"}); err == nil {
+		t.Errorf("Expected an error once attempts ran out")
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Did not stop at max attempts, got: %d calls, want: 3", calls.Load())
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	// A bad token and mutations are never retried
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Retry: testRetry})
+	Execute[deviceName](context.Background(), client, Request{Query: "{ device { name } }"})
+	Execute[deviceName](context.Background(), client, Request{Query: "mutation { renameDevice }"})
+	if calls.Load() != 2 {
+		t.Errorf("Retried a request that should not be, got: %d calls, want: 2", calls.Load())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	// Jitter keeps each delay between half and all of the exponential value
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for i := 0; i < 20; i++ {
+			d := policy.Backoff(attempt, 0)
+			if d < max/2 || d > max {
+				t.Errorf("Attempt %d backoff out of range, got: %s, want: %s to %s", attempt, d, max/2, max)
+			}
+		}
+	}
+	// A longer Retry-After always wins
+	if d := policy.Backoff(1, 5*time.Second); d != 5*time.Second {
+		t.Errorf("Did not respect Retry-After, got: %s, want: 5s", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2018, 11, 2, 12, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("7", now); d != 7*time.Second {
+		t.Errorf("Seconds did not parse, got: %s", d)
+	}
+	if d := parseRetryAfter("Fri, 02 Nov 2018 12:00:30 GMT", now); d != 30*time.Second {
+		t.Errorf("HTTP date did not parse, got: %s", d)
+	}
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Errorf("Garbage should be ignored, got: %s", d)
+	}
+}
@@ -0,0 +1,204 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stream runs req and decodes the array at path in the response data one element at a time,
+// calling fn with each as it arrives, so large responses are never held in memory at once.
+// For example a path of group, devices hands over each device of data.group.devices.
+//
+// Failures are retried like Do until the first element has been handed to fn, after which the
+// error is returned as is. A partial response returns a ResponseError once every element has been
+// handed over, see IsPartial. Cached responses are streamed from disk, and a response is only
+// cached once all of it has been read without errors.
+func Stream[T any](ctx context.Context, c *Client, req Request, path []string, fn func(T) error) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshalling query: %w", err)
+	}
+	policy := c.Retry.withDefaults()
+	maxAttempts := policy.MaxAttempts
+	if !idempotent(req.Query) {
+		maxAttempts = 1
+	}
+	name := operationName(req.Query)
+	cacheKey, err := c.cacheKey(req)
+	if err != nil {
+		return err
+	}
+	if cacheKey != "" {
+		if cached, ok := c.Cache.Open(cacheKey); ok {
+			defer cached.Close()
+			count := &countingReader{r: cached}
+			n, err := decodeStream(count, path, fn)
+			c.Logger.Debug("graphql cache hit", "query", name, "bytes", count.n, "elements", n)
+			return err
+		}
+	}
+	for attempt := 1; ; attempt++ {
+		n, retryAfter, err := stream(ctx, c, name, attempt, b, cacheKey, path, fn)
+		if err == nil || n > 0 {
+			return err
+		}
+		if err := c.retry(ctx, policy, maxAttempts, name, attempt, err, retryAfter); err != nil {
+			return err
+		}
+	}
+}
+
+// Makes a single attempt of Stream, returning how many elements were handed to fn
+func stream[T any](ctx context.Context, c *Client, name string, attempt int, b []byte, cacheKey string, path []string, fn func(T) error) (int, time.Duration, error) {
+	resp, start, retryAfter, err := c.send(ctx, name, attempt, b)
+	if err != nil {
+		return 0, retryAfter, err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	// Copy the response into a cache entry as it is read, only kept if it all decodes cleanly
+	var cached *entry
+	if cacheKey != "" {
+		if cached, err = c.Cache.create(cacheKey); err == nil {
+			body = io.TeeReader(body, cached)
+		}
+	}
+	count := &countingReader{r: body}
+	n, err := decodeStream(count, path, fn)
+	c.Logger.Debug("graphql request", "query", name, "attempt", attempt, "duration", time.Since(start), "status", resp.StatusCode, "bytes", count.n, "elements", n)
+	if cached != nil {
+		// Drain what is left after the array so the entry holds the whole response
+		if _, drainErr := io.Copy(io.Discard, count); err == nil && drainErr == nil {
+			cached.commit()
+		} else {
+			cached.abort()
+		}
+	}
+	return n, 0, err
+}
+
+// Walks the response envelope token by token, decoding each element of the array at path in data into a T.
+// Returns how many elements were handed to fn.
+func decodeStream[T any](r io.Reader, path []string, fn func(T) error) (int, error) {
+	dec := json.NewDecoder(r)
+	var errs []Error
+	hasData, n := false, 0
+	if err := expectDelim(dec, '{'); err != nil {
+		return 0, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return n, readError(err)
+		}
+		switch key {
+		case "data":
+			// Already wrapped, and may be an error from fn
+			if hasData, err = walkStream(dec, path, fn, &n); err != nil {
+				return n, err
+			}
+		case "errors":
+			err = dec.Decode(&errs)
+		default:
+			err = dec.Decode(&json.RawMessage{})
+		}
+		if err != nil {
+			return n, readError(err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return n, err
+	}
+	if len(errs) > 0 {
+		return n, &ResponseError{Errors: errs, Partial: hasData}
+	}
+	if !hasData {
+		return n, errors.New("response contained no data")
+	}
+	return n, nil
+}
+
+// Follows path down from the current value and streams the array at its end.
+// A null anywhere along the way, e.g. a field that failed to resolve, means no elements.
+// Reports whether the current value held any data.
+func walkStream[T any](dec *json.Decoder, path []string, fn func(T) error, n *int) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, readError(err)
+	}
+	if tok == nil {
+		return false, nil
+	}
+	if len(path) == 0 {
+		if tok != json.Delim('[') {
+			return false, fmt.Errorf("decoding response data: expected an array, got %v", tok)
+		}
+		for dec.More() {
+			var elem T
+			if err := dec.Decode(&elem); err != nil {
+				return true, readError(err)
+			}
+			*n++
+			if err := fn(elem); err != nil {
+				return true, err
+			}
+		}
+		return true, expectDelim(dec, ']')
+	}
+	if tok != json.Delim('{') {
+		return false, fmt.Errorf("decoding response data: expected an object at %s, got %v", path[0], tok)
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return true, readError(err)
+		}
+		if key == path[0] {
+			if _, err := walkStream(dec, path[1:], fn, n); err != nil {
+				return true, err
+			}
+		} else if err := dec.Decode(&json.RawMessage{}); err != nil {
+			return true, readError(err)
+		}
+	}
+	return true, expectDelim(dec, '}')
+}
+
+// Reads the next token and checks it is the delimiter d
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return readError(err)
+	}
+	if tok != d {
+		return fmt.Errorf("decoding response: expected %v, got %v", d, tok)
+	}
+	return nil
+}
+
+// Syntax and type errors mean a bad response. Anything else, including a body cut short, failed
+// reading the body and is worth retrying.
+func readError(err error) error {
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntax) || errors.As(err, &typeErr) {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return &transportError{"reading response", err}
+}
+
+// Counts the bytes read through it for the request log
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
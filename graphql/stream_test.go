@@ -0,0 +1,129 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const groupQuery = `query group($groupId: Int64!) { group(id: $groupId) { name devices { name } } }`
+
+type streamDevice struct {
+	Name string
+}
+
+// Collects the names of the streamed devices
+func streamNames(ctx context.Context, client *Client) ([]string, error) {
+	var names []string
+	err := Stream(ctx, client, Request{Query: groupQuery, Variables: Variables{"groupId": 4656, "endTime": 1541168971265}},
+		[]string{"group", "devices"}, func(d streamDevice) error {
+			names = append(names, d.Name)
+			return nil
+		})
+	return names, err
+}
+
+func TestStream(t *testing.T) {
+	// Sibling fields before and after the array are skipped
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"group": {"name": "Owl", "devices": [{"name": "Truck 1"}, {"name": "Truck 2"}], "tags": [1]}}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL})
+	names, err := streamNames(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if want := []string{"Truck 1", "Truck 2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Did not stream the devices, got: %v, want: %v", names, want)
+	}
+}
+
+func TestStreamPartial(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors": [{"message": "device 2 unavailable"}], "data": {"group": {"devices": [{"name": "Truck 1"}]}}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL})
+	names, err := streamNames(context.Background(), client)
+	if !IsPartial(err) {
+		t.Errorf("Expected a partial error, got: %v", err)
+	}
+	if len(names) != 1 {
+		t.Errorf("Did not stream the data with the errors, got: %v", names)
+	}
+
+	// A field that failed to resolve is null, which is no data rather than a decoding error
+	nullServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": null, "errors": [{"message": "group not found"}]}`))
+	}))
+	defer nullServer.Close()
+	_, err = streamNames(context.Background(), NewClient(Options{Endpoint: nullServer.URL}))
+	if err == nil || IsPartial(err) || !strings.Contains(err.Error(), "group not found") {
+		t.Errorf("Expected the graphQL error, got: %v", err)
+	}
+}
+
+func TestStreamRetry(t *testing.T) {
+	// Nothing was handed over before the 503, so the request is tried again
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data": {"group": {"devices": [{"name": "Truck 1"}]}}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(Options{Endpoint: server.URL, Retry: testRetry})
+	names, err := streamNames(context.Background(), client)
+	if err != nil || len(names) != 1 || calls.Load() != 2 {
+		t.Errorf("Did not retry, got: %v, %v after %d calls", names, err, calls.Load())
+	}
+
+	// Once a device has been handed over a retry would repeat it, so a cut off body is returned as is
+	calls.Store(0)
+	cutServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Length", "200")
+		w.Write([]byte(`{"data": {"group": {"devices": [{"name": "Truck 1"}, {"na`))
+	}))
+	defer cutServer.Close()
+	client = NewClient(Options{Endpoint: cutServer.URL, Retry: testRetry})
+	names, err = streamNames(context.Background(), client)
+	if err == nil || len(names) != 1 || calls.Load() != 1 {
+		t.Errorf("Retried after streaming, got: %v, %v after %d calls", names, err, calls.Load())
+	}
+}
+
+func TestStreamCache(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"data": {"group": {"devices": [{"name": "Truck 1"}, {"name": "Truck 2"}]}}}`))
+	}))
+	defer server.Close()
+
+	cache := NewCache(t.TempDir(), time.Hour)
+	client := NewClient(Options{Endpoint: server.URL, Cache: cache})
+	for i := 0; i < 2; i++ {
+		names, err := streamNames(context.Background(), client)
+		if err != nil || len(names) != 2 {
+			t.Fatalf("Did not stream the devices, got: %v, %v", names, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Streamed query was not cached, got: %d calls, want: 1", calls.Load())
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Did not count cache use, got: %+v", stats)
+	}
+}
@@ -0,0 +1,133 @@
+package graphql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Network - Proxy and TLS settings for reaching the API from networks that need them, e.g. a corporate proxy
+// that re-signs traffic with a private root CA
+type Network struct {
+	Proxy         string // Proxy URL for every request, empty uses HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment
+	CAFile        string // PEM bundle of extra root CAs, trusted on top of the system roots
+	CertFile      string // PEM client certificate for mutual TLS
+	KeyFile       string // PEM private key of CertFile
+	MinTLSVersion string // Lowest TLS version accepted, "1.2" (the default) or "1.3"
+}
+
+// TLSVersion parses a version as written in config, e.g. "1.3". Empty means TLS 1.2.
+func TLSVersion(v string) (uint16, error) {
+	switch v {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, use 1.2 or 1.3", v)
+}
+
+// NewTransport builds the pooled transport every request goes through, with n's proxy, root CAs,
+// client certificate and minimum TLS version applied
+func NewTransport(n Network) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 16
+	if n.Proxy != "" {
+		proxy, err := parseProxy(n.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	tlsConfig, err := n.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// ProxyFor reports the proxy a request to endpoint goes through, nil when it goes direct
+func (n Network) ProxyFor(endpoint string) (*url.URL, error) {
+	if n.Proxy != "" {
+		return parseProxy(n.Proxy)
+	}
+	req, err := http.NewRequest("POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// RootCAs loads the system roots plus the certificates of CAFile, nil when only the system roots are trusted
+func (n Network) RootCAs() (*x509.CertPool, error) {
+	if n.CAFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(n.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s has no PEM certificates", n.CAFile)
+	}
+	return pool, nil
+}
+
+// ClientCertificate loads the certificate for mutual TLS, nil when none is configured
+func (n Network) ClientCertificate() (*tls.Certificate, error) {
+	if n.CertFile == "" && n.KeyFile == "" {
+		return nil, nil
+	}
+	if n.CertFile == "" || n.KeyFile == "" {
+		return nil, errors.New("a client certificate needs both a cert file and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(n.CertFile, n.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading client certificate: %w", err)
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	}
+	return &cert, nil
+}
+
+func (n Network) tlsConfig() (*tls.Config, error) {
+	minVersion, err := TLSVersion(n.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := n.RootCAs()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{MinVersion: minVersion, RootCAs: roots}
+	cert, err := n.ClientCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config, nil
+}
+
+func parseProxy(raw string) (*url.URL, error) {
+	proxy, err := url.Parse(raw)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("proxy must be a URL such as http://proxy.example.com:3128, got %q", raw)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+		return proxy, nil
+	}
+	return nil, fmt.Errorf("proxy scheme must be http, https or socks5, got %q", proxy.Scheme)
+}
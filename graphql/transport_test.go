@@ -0,0 +1,177 @@
+package graphql
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Writes the certificate of a TLS test server to a PEM file, to use as a CA bundle
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Writes a self signed client certificate and its key, returning their paths and the certificate
+func writeClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "owl-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certPath, keyPath, cert
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"data": {"ok": true}}`))
+}
+
+func networkClient(t *testing.T, endpoint string, n Network) *Client {
+	transport, err := NewTransport(n)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	return NewClient(Options{Endpoint: endpoint, Transport: transport, Retry: RetryPolicy{MaxAttempts: 1}})
+}
+
+func TestTransportCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(okHandler))
+	defer server.Close()
+
+	var data map[string]bool
+	err := networkClient(t, server.URL, Network{}).Do(context.Background(), Request{Query: "{ ok }"}, &data)
+	if err == nil {
+		t.Fatalf("Expected an unknown authority error without the CA bundle")
+	}
+	client := networkClient(t, server.URL, Network{CAFile: writeServerCA(t, server)})
+	if err := client.Do(context.Background(), Request{Query: "{ ok }"}, &data); err != nil || !data["ok"] {
+		t.Errorf("Expected the CA bundle to be trusted, got: %v %v", data, err)
+	}
+}
+
+func TestTransportClientCertificate(t *testing.T) {
+	certPath, keyPath, cert := writeClientCert(t)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "owl-test" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		okHandler(w, r)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+	ca := writeServerCA(t, server)
+
+	var data map[string]bool
+	if err := networkClient(t, server.URL, Network{CAFile: ca}).Do(context.Background(), Request{Query: "{ ok }"}, &data); err == nil {
+		t.Errorf("Expected the handshake to fail without a client certificate")
+	}
+	client := networkClient(t, server.URL, Network{CAFile: ca, CertFile: certPath, KeyFile: keyPath})
+	if err := client.Do(context.Background(), Request{Query: "{ ok }"}, &data); err != nil || !data["ok"] {
+		t.Errorf("Expected the client certificate to be accepted, got: %v %v", data, err)
+	}
+}
+
+func TestTransportMinTLSVersion(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(okHandler))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	ca := writeServerCA(t, server)
+
+	var data map[string]bool
+	if err := networkClient(t, server.URL, Network{CAFile: ca}).Do(context.Background(), Request{Query: "{ ok }"}, &data); err != nil {
+		t.Errorf("Expected TLS 1.2 to be accepted by default, got: %s", err)
+	}
+	if err := networkClient(t, server.URL, Network{CAFile: ca, MinTLSVersion: "1.3"}).Do(context.Background(), Request{Query: "{ ok }"}, &data); err == nil {
+		t.Errorf("Expected a TLS 1.2 server to be refused with a 1.3 minimum")
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+	var proxied atomic.Int64
+	// A plain http endpoint is requested from the proxy with its absolute URL
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host == "api.example.invalid" {
+			proxied.Add(1)
+			okHandler(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	var data map[string]bool
+	client := networkClient(t, "http://api.example.invalid/graphql", Network{Proxy: proxy.URL})
+	if err := client.Do(context.Background(), Request{Query: "{ ok }"}, &data); err != nil || !data["ok"] {
+		t.Fatalf("Expected the request to go through the proxy, got: %v %v", data, err)
+	}
+	if proxied.Load() != 1 {
+		t.Errorf("Expected 1 proxied request, got %d", proxied.Load())
+	}
+	if got, err := (Network{Proxy: proxy.URL}).ProxyFor("https://api.samsara.com"); err != nil || got.String() != proxy.URL {
+		t.Errorf("Expected ProxyFor to report %s, got: %v %v", proxy.URL, got, err)
+	}
+}
+
+func TestNewTransportErrors(t *testing.T) {
+	certPath, _, _ := writeClientCert(t)
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0o600)
+	for name, n := range map[string]Network{
+		"proxy":       {Proxy: "proxy.example.com"},
+		"scheme":      {Proxy: "ftp://proxy.example.com"},
+		"tls version": {MinTLSVersion: "1.1"},
+		"missing CA":  {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"empty CA":    {CAFile: empty},
+		"no key":      {CertFile: certPath},
+		"bad key":     {CertFile: certPath, KeyFile: empty},
+	} {
+		if _, err := NewTransport(n); err == nil {
+			t.Errorf("%s: expected an error for %+v", name, n)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Package mock is a stand-in for the Samsara graphQL API, answering the device.objectStat,
+// group.devices.vehicleActivityReport and group.addresses shapes the tools query from
+// fixture files. It runs under httptest in tests and as the mockServer command for demos,
+// and can inject latency, 429s and graphQL errors.
+package mock
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed fixtures/samsara.json
+var defaultFixtures []byte
+
+// Path the mock serves graphQL on, matching the real endpoint
+const Path = "/v1/admin/graphql"
+
+// Options - Faults to inject, all off by default
+type Options struct {
+	Token       string        // When set, requests must send this X-Access-Token or get a 401
+	Latency     time.Duration // Added before every response
+	Throttle    int           // Answer this many requests with 429 before serving
+	RetryAfter  time.Duration // Retry-After sent with throttled responses, none when zero
+	ErrorField  string        // Field name that resolves to a graphQL error instead of data, e.g. vehicleActivityReport
+	FailDevices []int64       // Device IDs whose device(id) lookups fail with a graphQL error, e.g. a broken vehicle
+}
+
+// Data - Fixture data: "devices" and "groups" lists, see fixtures/samsara.json
+type Data map[string]interface{}
+
+// DefaultFixtures returns the bundled fixture data
+func DefaultFixtures() (Data, error) {
+	return decodeFixtures(defaultFixtures)
+}
+
+// LoadFixtures reads fixture data from a JSON file
+func LoadFixtures(path string) (Data, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFixtures(b)
+}
+
+// Numbers are kept as json.Number so 15 digit device IDs compare exactly
+func decodeFixtures(b []byte) (Data, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var data Data
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("decoding fixtures: %w", err)
+	}
+	return data, nil
+}
+
+// Server - http.Handler answering graphQL requests from fixture data
+type Server struct {
+	data       Data
+	opts       Options
+	requests   atomic.Int64
+	authorized atomic.Int64 // Requests past the token check, which Throttle counts against
+}
+
+// NewServer creates a handler for data with the given faults
+func NewServer(data Data, opts Options) *Server {
+	return &Server{data: data, opts: opts}
+}
+
+// NewTestServer starts an httptest server with the bundled fixtures. Its endpoint is
+// server.URL + Path.
+func NewTestServer(opts Options) *httptest.Server {
+	data, err := DefaultFixtures()
+	if err != nil {
+		panic(err)
+	}
+	return httptest.NewServer(NewServer(data, opts))
+}
+
+// Requests returns how many requests have been received, throttled ones included
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+// Graph error entry in the response
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	if s.opts.Latency > 0 {
+		select {
+		case <-time.After(s.opts.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "graphQL requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.opts.Token != "" && r.Header.Get("X-Access-Token") != s.opts.Token {
+		http.Error(w, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	if s.authorized.Add(1) <= int64(s.opts.Throttle) {
+		if s.opts.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.opts.RetryAfter.Seconds()))))
+		}
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "could not decode request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fields, err := parseQuery(req.Query, req.Variables)
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": nil, "errors": []gqlError{{Message: "Syntax Error: " + err.Error()}}})
+		return
+	}
+	res := &resolver{data: s.data, opts: s.opts}
+	data := map[string]interface{}{}
+	for _, f := range fields {
+		data[f.key()] = res.root(f)
+	}
+	resp := map[string]interface{}{"data": data}
+	if len(res.errors) > 0 {
+		resp["errors"] = res.errors
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Converts fixture and variable numbers to int64
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case float64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,120 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+func TestParseQuery(t *testing.T) {
+	query := `query batch($a: Int64!, $end: Int64!) {
+		d1: device(id: $a) { name objectStat(statTypeEnum: osDDashcamState, endTime: $end, duration: 60000) { intValue } }
+		# Comments and commas are ignored,
+		d2: device(id: 5, label: "two") { name }
+	}`
+	fields, err := parseQuery(query, map[string]interface{}{"a": 7, "end": 100})
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if len(fields) != 2 || fields[0].key() != "d1" || fields[0].name != "device" || fields[1].args["label"] != "two" {
+		t.Fatalf("Did not parse the aliases, got: %+v %+v", fields[0], fields[1])
+	}
+	stat := fields[0].selections[1]
+	if stat.args["statTypeEnum"] != "osDDashcamState" || stat.args["endTime"] != 100 {
+		t.Errorf("Did not resolve the arguments, got: %v", stat.args)
+	}
+	if _, err := parseQuery(`{ device(id: $missing) { name } }`, nil); err == nil {
+		t.Errorf("Expected an error for an undefined variable")
+	}
+}
+
+type deviceStats struct {
+	Device struct {
+		Name  string
+		Group struct{ Name string }
+		Stats []struct {
+			ChangedAtMs int
+			IntValue    int
+		} `json:"objectStat"`
+	}
+}
+
+const statsQuery = `query stats($deviceId: Int64!, $endTime: Int64!, $duration: Int64!) {
+	device(id: $deviceId) {
+		name
+		group { name }
+		objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) { changedAtMs intValue }
+	}
+}`
+
+func TestServerAnswersFixtures(t *testing.T) {
+	server := NewTestServer(Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + Path})
+
+	req := graphql.Request{Query: statsQuery, Variables: graphql.Variables{"deviceId": 212014918236538, "endTime": 1541168971265, "duration": 3123000}}
+	result, err := graphql.Execute[deviceStats](context.Background(), client, req)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// Only the state changes inside the window come back
+	if result.Device.Name != "Truck 1" || result.Device.Group.Name != "Owl Test Group" || len(result.Device.Stats) != 9 {
+		t.Errorf("Did not answer from the fixtures, got: %+v", result.Device)
+	}
+
+	req.Variables["deviceId"] = 1
+	_, err = graphql.Execute[deviceStats](context.Background(), client, req)
+	var respErr *graphql.ResponseError
+	if !errors.As(err, &respErr) || respErr.Errors[0].PathString() != "device" {
+		t.Errorf("Expected a not found error on device, got: %v", err)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	server := NewTestServer(Options{Token: "secret", Throttle: 1, ErrorField: "objectStat", Latency: 10 * time.Millisecond})
+	defer server.Close()
+	req := graphql.Request{Query: statsQuery, Variables: graphql.Variables{"deviceId": 212014918236538, "endTime": 1541168971265, "duration": 3123000}}
+
+	wrongToken := graphql.NewClient(graphql.Options{Endpoint: server.URL + Path, Token: "wrong"})
+	_, err := graphql.Execute[deviceStats](context.Background(), wrongToken, req)
+	var statusErr *graphql.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a 401, got: %v", err)
+	}
+
+	// The throttled first request is retried, then the injected field error comes back as partial data
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + Path, Token: "secret", Retry: graphql.RetryPolicy{MaxDelay: 10 * time.Millisecond}})
+	client.OnRetry = func(attempt int, err error, wait time.Duration) {}
+	result, err := graphql.Execute[deviceStats](context.Background(), client, req)
+	if !graphql.IsPartial(err) {
+		t.Fatalf("Expected partial data from the injected error, got: %v", err)
+	}
+	if result.Device.Name != "Truck 1" {
+		t.Errorf("Partial data missing, got: %+v", result.Device)
+	}
+	if client.Stats().Retries != 1 {
+		t.Errorf("Throttled request was not retried, got: %+v", client.Stats())
+	}
+}
+
+func TestServerFailDevices(t *testing.T) {
+	server := NewTestServer(Options{FailDevices: []int64{212014918236538}})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + Path})
+	req := graphql.Request{Query: statsQuery, Variables: graphql.Variables{"deviceId": 212014918236538, "endTime": 1541168971265, "duration": 3123000}}
+
+	_, err := graphql.Execute[deviceStats](context.Background(), client, req)
+	if err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Expected the failed device error, got: %v", err)
+	}
+	// Other devices still answer
+	req.Variables["deviceId"] = 212014918137973
+	if _, err := graphql.Execute[deviceStats](context.Background(), client, req); err != nil {
+		t.Errorf("Received an error: %s", err)
+	}
+}
@@ -0,0 +1,219 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Field - One selected field of a query, with its arguments resolved against the variables
+type field struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []*field
+}
+
+// Key the field is returned under
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+// Parses just enough of a graphQL document for the mock: one operation, fields, aliases and
+// arguments. Variable definitions and directives are skipped.
+func parseQuery(query string, variables map[string]interface{}) ([]*field, error) {
+	p := &parser{tokens: lex(query), vars: variables}
+	if p.peek() == "query" {
+		p.next()
+		if p.peek() != "{" && p.peek() != "(" {
+			p.next() // Operation name
+		}
+		if p.peek() == "(" {
+			p.skipParens()
+		}
+	}
+	fields, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf("unexpected %q after the selection set", p.peek())
+	}
+	return fields, nil
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	vars   map[string]interface{}
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *parser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *parser) skipParens() {
+	depth := 0
+	for p.peek() != "" {
+		switch p.next() {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *parser) selectionSet() ([]*field, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []*field
+	for p.peek() != "}" {
+		if p.peek() == "" {
+			return nil, fmt.Errorf("unclosed selection set")
+		}
+		f, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.next()
+	return fields, nil
+}
+
+func (p *parser) field() (*field, error) {
+	f := &field{name: p.next(), args: map[string]interface{}{}}
+	if !isName(f.name) {
+		return nil, fmt.Errorf("expected a field name, got %q", f.name)
+	}
+	if p.peek() == ":" {
+		p.next()
+		f.alias, f.name = f.name, p.next()
+		if !isName(f.name) {
+			return nil, fmt.Errorf("expected a field name after alias %s, got %q", f.alias, f.name)
+		}
+	}
+	if p.peek() == "(" {
+		p.next()
+		for p.peek() != ")" {
+			name := p.next()
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			f.args[name] = value
+		}
+		p.next()
+	}
+	if p.peek() == "{" {
+		selections, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+		f.selections = selections
+	}
+	return f, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	tok := p.next()
+	switch {
+	case tok == "$":
+		name := p.next()
+		v, ok := p.vars[name]
+		if !ok {
+			return nil, fmt.Errorf("variable $%s is not defined", name)
+		}
+		return v, nil
+	case strings.HasPrefix(tok, `"`):
+		return strconv.Unquote(tok)
+	case tok == "true" || tok == "false":
+		return tok == "true", nil
+	case tok == "null":
+		return nil, nil
+	case tok != "" && (unicode.IsDigit(rune(tok[0])) || tok[0] == '-'):
+		return json.Number(tok), nil
+	case isName(tok):
+		return tok, nil // Enum value
+	}
+	return nil, fmt.Errorf("unexpected %q in arguments", tok)
+}
+
+func isName(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	for i, r := range tok {
+		if !(r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+// Splits a document into names, numbers, strings and punctuation, dropping commas and comments
+func lex(query string) []string {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			i++
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, string(runes[i:min(j+1, len(runes))]))
+			i = j + 1
+		case r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			j := i + 1
+			for j < len(runes) && (runes[j] == '_' || runes[j] == '.' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		default:
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+	return tokens
+}
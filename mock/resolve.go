@@ -0,0 +1,225 @@
+package mock
+
+import (
+	"fmt"
+)
+
+// Resolves the fields of one request against the fixture data, collecting errors as it goes
+type resolver struct {
+	data   Data
+	opts   Options
+	errors []gqlError
+}
+
+// Fields that take arguments or link objects together, keyed by parent type and field name.
+// Every other field is read straight from the fixture object.
+var fieldResolvers = map[string]func(r *resolver, parent map[string]interface{}, f *field) (interface{}, error){
+	"Device.group":                 (*resolver).deviceGroup,
+	"Device.objectStat":            (*resolver).objectStat,
+	"Device.vehicleActivityReport": (*resolver).vehicleActivityReport,
+	"Group.devices":                (*resolver).groupDevices,
+}
+
+// Resolves a root field: device(id) or group(id)
+func (r *resolver) root(f *field) interface{} {
+	path := []interface{}{f.key()}
+	var list string
+	var typename string
+	switch f.name {
+	case "device":
+		list, typename = "devices", "Device"
+	case "group":
+		list, typename = "groups", "Group"
+	default:
+		r.fail(path, fmt.Sprintf("Cannot query field %q on type \"Query\"", f.name))
+		return nil
+	}
+	if f.name == "device" && r.failDevice(f.args["id"]) {
+		r.fail(path, fmt.Sprintf("device %v is unavailable", f.args["id"]))
+		return nil
+	}
+	obj := r.find(list, f.args["id"])
+	if obj == nil {
+		r.fail(path, fmt.Sprintf("%s %v not found", f.name, f.args["id"]))
+		return nil
+	}
+	return r.project(typename, obj, f, path)
+}
+
+// Builds the selected fields of obj
+func (r *resolver) project(typename string, obj map[string]interface{}, f *field, path []interface{}) interface{} {
+	out := map[string]interface{}{}
+	for _, sel := range f.selections {
+		selPath := append(append([]interface{}{}, path...), sel.key())
+		if r.opts.ErrorField != "" && sel.name == r.opts.ErrorField {
+			r.fail(selPath, "injected error for "+sel.name)
+			out[sel.key()] = nil
+			continue
+		}
+		var value interface{}
+		if resolve, ok := fieldResolvers[typename+"."+sel.name]; ok {
+			v, err := resolve(r, obj, sel)
+			if err != nil {
+				r.fail(selPath, err.Error())
+				out[sel.key()] = nil
+				continue
+			}
+			value = v
+		} else {
+			v, ok := obj[sel.name]
+			if !ok {
+				r.fail(selPath, fmt.Sprintf("Cannot query field %q on type %q", sel.name, typename))
+				out[sel.key()] = nil
+				continue
+			}
+			value = v
+		}
+		out[sel.key()] = r.selectValue(childType(typename, sel.name), value, sel, selPath)
+	}
+	return out
+}
+
+// Applies the selection set of f to a value, which may be an object, a list or a scalar
+func (r *resolver) selectValue(typename string, value interface{}, f *field, path []interface{}) interface{} {
+	if len(f.selections) == 0 {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return r.project(typename, v, f, path)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.selectValue(typename, item, f, append(append([]interface{}{}, path...), i))
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.project(typename, item, f, append(append([]interface{}{}, path...), i))
+		}
+		return out
+	}
+	return value
+}
+
+// Type of the objects a field returns, where it matters for resolving the next level
+func childType(parent, name string) string {
+	switch parent + "." + name {
+	case "Device.group":
+		return "Group"
+	case "Group.devices":
+		return "Device"
+	}
+	return "Object"
+}
+
+func (r *resolver) fail(path []interface{}, message string) {
+	r.errors = append(r.errors, gqlError{Message: message, Path: path})
+}
+
+// Reports whether the device id is one of the injected FailDevices
+func (r *resolver) failDevice(id interface{}) bool {
+	want, ok := toInt(id)
+	if !ok {
+		return false
+	}
+	for _, failed := range r.opts.FailDevices {
+		if failed == want {
+			return true
+		}
+	}
+	return false
+}
+
+// Finds the object with the given id in one of the fixture lists
+func (r *resolver) find(list string, id interface{}) map[string]interface{} {
+	want, ok := toInt(id)
+	if !ok {
+		return nil
+	}
+	items, _ := r.data[list].([]interface{})
+	for _, item := range items {
+		obj, _ := item.(map[string]interface{})
+		if got, ok := toInt(obj["id"]); ok && got == want {
+			return obj
+		}
+	}
+	return nil
+}
+
+func (r *resolver) deviceGroup(device map[string]interface{}, f *field) (interface{}, error) {
+	group := r.find("groups", device["groupId"])
+	if group == nil {
+		return nil, fmt.Errorf("group %v not found", device["groupId"])
+	}
+	return group, nil
+}
+
+func (r *resolver) groupDevices(group map[string]interface{}, f *field) (interface{}, error) {
+	groupID, _ := toInt(group["id"])
+	var devices []map[string]interface{}
+	items, _ := r.data["devices"].([]interface{})
+	for _, item := range items {
+		device, _ := item.(map[string]interface{})
+		if id, ok := toInt(device["groupId"]); ok && id == groupID {
+			devices = append(devices, device)
+		}
+	}
+	return devices, nil
+}
+
+// Reads the endTime and duration arguments as a [start, end] window
+func window(f *field) (int64, int64, error) {
+	end, ok := toInt(f.args["endTime"])
+	if !ok {
+		return 0, 0, fmt.Errorf("%s needs an endTime", f.name)
+	}
+	duration, ok := toInt(f.args["duration"])
+	if !ok || duration < 0 {
+		return 0, 0, fmt.Errorf("%s needs a non-negative duration", f.name)
+	}
+	return end - duration, end, nil
+}
+
+// Stat values that changed within the window, from the fixture list named after the stat type
+func (r *resolver) objectStat(device map[string]interface{}, f *field) (interface{}, error) {
+	start, end, err := window(f)
+	if err != nil {
+		return nil, err
+	}
+	statType, _ := f.args["statTypeEnum"].(string)
+	stats, ok := device[statType].([]interface{})
+	if !ok {
+		stats = nil
+	}
+	out := []interface{}{}
+	for _, s := range stats {
+		stat, _ := s.(map[string]interface{})
+		if at, ok := toInt(stat["changedAtMs"]); ok && at >= start && at <= end {
+			out = append(out, stat)
+		}
+	}
+	return out, nil
+}
+
+// Trips that overlap the window
+func (r *resolver) vehicleActivityReport(device map[string]interface{}, f *field) (interface{}, error) {
+	start, end, err := window(f)
+	if err != nil {
+		return nil, err
+	}
+	trips, _ := device["trips"].([]interface{})
+	entries := []interface{}{}
+	for _, t := range trips {
+		trip, _ := t.(map[string]interface{})
+		tripStart, _ := trip["start"].(map[string]interface{})
+		tripEnd, _ := trip["end"].(map[string]interface{})
+		startTime, _ := toInt(tripStart["time"])
+		endTime, _ := toInt(tripEnd["time"])
+		if endTime >= start && startTime <= end {
+			entries = append(entries, trip)
+		}
+	}
+	return map[string]interface{}{"tripEntries": entries}, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+// Serves the mock Samsara graphQL API for demos. Point a tool at it with
+// "endpoint": "http://localhost:8080/v1/admin/graphql" in its config, or OWL_ENDPOINT.
+func main() {
+	addr := flag.String("addr", "localhost:8080", "Address to listen on")
+	fixtures := flag.String("fixtures", "", "Fixture file to serve instead of the bundled one")
+	token := flag.String("token", "", "Require this X-Access-Token (any token is accepted when empty)")
+	latency := flag.Duration("latency", 0, "Delay added to every response, e.g. 500ms")
+	throttle := flag.Int("throttle", 0, "Answer this many requests with 429 before serving")
+	retryAfter := flag.Duration("retry-after", 0, "Retry-After sent with throttled responses, e.g. 2s")
+	errorField := flag.String("error-field", "", "Field that resolves to a graphQL error, e.g. vehicleActivityReport")
+	failDevices := flag.String("fail-devices", "", "Comma separated device IDs whose lookups fail, e.g. 212014918137973")
+	flag.Parse()
+
+	data, err := mock.DefaultFixtures()
+	if *fixtures != "" {
+		data, err = mock.LoadFixtures(*fixtures)
+	}
+	if err != nil {
+		fmt.Println("Could not load the fixtures:", err)
+		return
+	}
+
+	var failed []int64
+	for _, id := range strings.Split(*failDevices, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			fmt.Println("Could not read --fail-devices:", err)
+			return
+		}
+		failed = append(failed, n)
+	}
+
+	server := mock.NewServer(data, mock.Options{
+		Token:       *token,
+		Latency:     *latency,
+		Throttle:    *throttle,
+		RetryAfter:  *retryAfter,
+		ErrorField:  *errorField,
+		FailDevices: failed,
+	})
+	mux := http.NewServeMux()
+	mux.Handle(mock.Path, server)
+
+	fmt.Printf("Mock Samsara graphQL listening on http://%s%s\n", *addr, mock.Path)
+	httpServer := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := httpServer.ListenAndServe(); err != nil {
+		fmt.Println("Server stopped:", err)
+	}
+}
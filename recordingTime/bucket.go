@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// bucket - The time spent in each state during one calendar hour, day or week of the window
+type bucket struct {
+	start       int // First ms of the bucket inside the window
+	end         int // First ms after it, the start of the next bucket or the end of the window
+	label       string
+	recording   int
+	stateTotals map[dashcamState]int
+	unknownMs   int
+}
+
+// bucketing - Splits time at the calendar boundaries of one bucket size in a timezone
+type bucketing struct {
+	name    string
+	heading string                      // Column heading of the bucket labels
+	start   func(t time.Time) time.Time // Start of the bucket t falls in
+	next    func(start time.Time) time.Time
+	label   string // Layout for the bucket column
+}
+
+// Bucket sizes of --bucket. Days and weeks start at local midnight, so across a DST change a day lasts 23
+// or 25 hours. Hours start at local minute 0, found from the instant rather than the wall clock, so the
+// repeated hour when clocks go back is two buckets.
+var bucketings = map[string]bucketing{
+	"hour": {
+		name:    "hour",
+		heading: "Hour",
+		start: func(t time.Time) time.Time {
+			return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+		},
+		next: func(start time.Time) time.Time {
+			n := start.Add(time.Hour)
+			// A half hour DST shift leaves the next hour off minute 0
+			return n.Add(-time.Duration(n.Minute()) * time.Minute)
+		},
+		label: "2006-01-02 15:04 MST",
+	},
+	"day": {
+		name:    "day",
+		heading: "Day",
+		start: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		},
+		next: func(start time.Time) time.Time {
+			return time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, start.Location())
+		},
+		label: "2006-01-02 Mon",
+	},
+	"week": {
+		name:    "week",
+		heading: "Week",
+		start: func(t time.Time) time.Time {
+			// Weeks start on Monday, as in ISO 8601
+			back := (int(t.Weekday()) + 6) % 7
+			return time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+		},
+		next: func(start time.Time) time.Time {
+			return time.Date(start.Year(), start.Month(), start.Day()+7, 0, 0, 0, 0, start.Location())
+		},
+		label: "Week of 2006-01-02",
+	},
+}
+
+// Looks up a --bucket value
+func parseBucket(name string) (bucketing, error) {
+	b, ok := bucketings[name]
+	if !ok {
+		return bucketing{}, fmt.Errorf("unknown bucket %q, use hour, day or week", name)
+	}
+	return b, nil
+}
+
+// Splits the window into calendar buckets in loc and totals the time spent in each state in each of them.
+// The first and last buckets are cut at the window, which ends now when it ends in the future.
+func bucketRecording(data recordData, startTimeMs, endTimeMs int, b bucketing, loc *time.Location) []bucket {
+	endTimeMs = min(endTimeMs, int(time.Now().UnixMilli()))
+	var buckets []bucket
+	for start := b.start(time.UnixMilli(int64(startTimeMs)).In(loc)); int(start.UnixMilli()) < endTimeMs; start = b.next(start) {
+		buckets = append(buckets, bucket{
+			start:       max(int(start.UnixMilli()), startTimeMs),
+			end:         min(int(b.next(start).UnixMilli()), endTimeMs),
+			label:       start.Format(b.label),
+			stateTotals: map[dashcamState]int{},
+		})
+	}
+	i := 0
+	for _, seg := range stateSegments(data, startTimeMs, endTimeMs) {
+		// Segments are in order, so the buckets before this one's start are done with
+		for i < len(buckets) && buckets[i].end <= seg.startTime {
+			i++
+		}
+		for j := i; j < len(buckets) && buckets[j].start < seg.endTime; j++ {
+			overlap := min(seg.endTime, buckets[j].end) - max(seg.startTime, buckets[j].start)
+			switch {
+			case !seg.state.known():
+				buckets[j].unknownMs += overlap
+			case seg.state == stateRecording:
+				buckets[j].recording += overlap
+				fallthrough
+			default:
+				buckets[j].stateTotals[seg.state] += overlap
+			}
+		}
+	}
+	return buckets
+}
+
+// Prints one row per bucket with the time in each state and the share of the bucket recorded
+func printBuckets(w io.Writer, buckets []bucket, b bucketing) {
+	fmt.Fprintf(w, " Time in each state by %s:\n", b.name)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "   "+b.heading)
+	for _, state := range dashcamStates {
+		fmt.Fprintf(tw, "\t%s", state)
+	}
+	fmt.Fprintln(tw, "\tRecorded")
+	for _, bk := range buckets {
+		fmt.Fprint(tw, "   "+bk.label)
+		for _, state := range dashcamStates {
+			fmt.Fprintf(tw, "\t%s", secToHours(bk.stateTotals[state]/1000))
+		}
+		fmt.Fprintf(tw, "\t%s\n", percent(bk.recording, bk.end-bk.start))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+}
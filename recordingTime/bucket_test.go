@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+func chicago(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skipf("No timezone database: %s", err)
+	}
+	return loc
+}
+
+func TestBucketRecordingDays(t *testing.T) {
+	loc := chicago(t)
+	ms := func(year int, month time.Month, day, hour int) int {
+		return int(time.Date(year, month, day, hour, 0, 0, 0, loc).UnixMilli())
+	}
+	day, _ := parseBucket("day")
+
+	// Clocks went back on 2018-11-04, recording from noon on the 3rd to noon on the 5th
+	data := stats([2]int{ms(2018, 11, 3, 12), 1}, [2]int{ms(2018, 11, 5, 12), 3})
+	buckets := bucketRecording(data, ms(2018, 11, 3, 0), ms(2018, 11, 6, 0), day, loc)
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 days, got: %+v", buckets)
+	}
+	hours := func(h int) int { return h * 3600000 }
+	want := []struct {
+		label     string
+		length    int
+		recording int
+		stopped   int
+	}{
+		{"2018-11-03 Sat", hours(24), hours(12), 0},
+		{"2018-11-04 Sun", hours(25), hours(25), 0},
+		{"2018-11-05 Mon", hours(24), hours(12), hours(12)},
+	}
+	for i, w := range want {
+		b := buckets[i]
+		if b.label != w.label || b.end-b.start != w.length || b.recording != w.recording || b.stateTotals[stateStopped] != w.stopped {
+			t.Errorf("Day %d: got %+v, want %+v", i, b, w)
+		}
+	}
+	// The morning before the first known state counts toward no state
+	if total := buckets[0].stateTotals[stateRecording] + buckets[0].unknownMs; len(buckets[0].stateTotals) != 1 || total != hours(12) {
+		t.Errorf("Expected only the afternoon of the first day in a state, got %+v", buckets[0])
+	}
+
+	// Clocks went forward on 2018-03-11, and the window starts and ends part way through a day
+	data = stats([2]int{ms(2018, 3, 10, 6), 1})
+	buckets = bucketRecording(data, ms(2018, 3, 10, 6), ms(2018, 3, 12, 6), day, loc)
+	if len(buckets) != 3 || buckets[0].end-buckets[0].start != hours(18) || buckets[1].end-buckets[1].start != hours(23) ||
+		buckets[2].end-buckets[2].start != hours(6) || buckets[1].recording != hours(23) {
+		t.Errorf("Unexpected days around spring forward: %+v", buckets)
+	}
+}
+
+func TestBucketRecordingHoursAndWeeks(t *testing.T) {
+	loc := chicago(t)
+	hour, _ := parseBucket("hour")
+
+	// 00:30 to 02:30 CST on 2018-11-04 is three hours of wall clock but four buckets, 01:00 happening twice
+	start := int(time.Date(2018, 11, 4, 0, 30, 0, 0, loc).UnixMilli())
+	end := start + 3*3600000
+	buckets := bucketRecording(stats([2]int{start, 1}), start, end, hour, loc)
+	var labels []string
+	for _, b := range buckets {
+		labels = append(labels, b.label)
+	}
+	if got := strings.Join(labels, ", "); got != "2018-11-04 00:00 CDT, 2018-11-04 01:00 CDT, 2018-11-04 01:00 CST, 2018-11-04 02:00 CST" {
+		t.Errorf("Unexpected hours: %s", got)
+	}
+	if buckets[0].recording != 1800000 || buckets[2].recording != 3600000 || buckets[3].recording != 1800000 {
+		t.Errorf("Unexpected hour totals: %+v", buckets)
+	}
+
+	// Weeks start on Monday, so a window from Wednesday to the next Tuesday is two partial weeks
+	week, _ := parseBucket("week")
+	start = int(time.Date(2018, 11, 7, 0, 0, 0, 0, loc).UnixMilli())
+	end = int(time.Date(2018, 11, 14, 0, 0, 0, 0, loc).UnixMilli())
+	buckets = bucketRecording(stats([2]int{start, 1}), start, end, week, loc)
+	if len(buckets) != 2 || buckets[0].label != "Week of 2018-11-05" || buckets[1].label != "Week of 2018-11-12" ||
+		buckets[1].start != int(time.Date(2018, 11, 12, 0, 0, 0, 0, loc).UnixMilli()) || buckets[0].recording+buckets[1].recording != end-start {
+		t.Errorf("Unexpected weeks: %+v", buckets)
+	}
+
+	if _, err := parseBucket("month"); err == nil {
+		t.Errorf("Expected an error for month")
+	}
+}
+
+func TestBucketRecordingFixture(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+	})
+	endTime, duration := 1541168971265, 3123000
+	data, err := recordingQuery(context.Background(), client, 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	hour, _ := parseBucket("hour")
+	buckets := bucketRecording(data, endTime-duration, endTime, hour, time.UTC)
+	if len(buckets) != 2 || buckets[0].label != "2018-11-02 13:00 UTC" || buckets[1].label != "2018-11-02 14:00 UTC" {
+		t.Fatalf("Expected the 13:00 and 14:00 hours, got: %+v", buckets)
+	}
+	// The buckets add up to the totals of the whole window
+	records := parseRecording(data, endTime-duration, endTime)
+	for _, state := range dashcamStates {
+		if sum := buckets[0].stateTotals[state] + buckets[1].stateTotals[state]; sum != records.stateTotals[state] {
+			t.Errorf("%s: buckets add up to %d, want %d", state, sum, records.stateTotals[state])
+		}
+	}
+	if buckets[0].recording+buckets[1].recording != records.totalRecord {
+		t.Errorf("Recording: buckets add up to %d, want %d", buckets[0].recording+buckets[1].recording, records.totalRecord)
+	}
+
+	var out strings.Builder
+	printBuckets(&out, buckets, hour)
+	if !strings.Contains(out.String(), "Time in each state by hour:") || !strings.Contains(out.String(), "2018-11-02 14:00 UTC") {
+		t.Errorf("Unexpected table:\n%s", out.String())
+	}
+}
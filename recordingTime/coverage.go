@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Query for the trips of a device over a window
+const tripsQueryDoc = `query trips($deviceId: Int64!, $endTime: Int64!, $duration: Int64!) {
+	device(id: $deviceId) {
+		vehicleActivityReport(endTime: $endTime, duration: $duration) {
+			tripEntries {
+				start {
+					time
+					address {
+						name
+					}
+				}
+				end {
+					time
+					address {
+						name
+					}
+				}
+			}
+		}
+	}
+}`
+
+// Structures to hold the trips returned from graphQL
+type tripData struct {
+	Device struct {
+		VehicleActivityReport struct {
+			TripEntries []trip
+		}
+	}
+}
+
+type trip struct {
+	Start tripPoint
+	End   tripPoint
+}
+
+type tripPoint struct {
+	Time    int
+	Address struct {
+		Name string
+	}
+}
+
+// interval - A stretch of time in ms, start inclusive and end exclusive
+type interval struct {
+	start int
+	end   int
+}
+
+// tripCoverage - How much of one trip's driving the dashcam recorded
+type tripCoverage struct {
+	trip       trip
+	driving    interval   // The part of the trip inside the window, which is all the states are known for
+	recordedMs int        // Driving time spent in the Recording state
+	unrecorded []interval // Driving time spent in any other state, or before the first known state
+}
+
+// coverage - How much of the driving over a window the dashcam recorded
+type coverage struct {
+	trips      []tripCoverage
+	drivingMs  int
+	recordedMs int
+}
+
+// Fetches the trips of a device that overlap a window, splitting long windows into chunks fetched concurrently
+func tripsQuery(ctx context.Context, client *graphql.Client, deviceID, endTimeMs, durationMs int) ([]trip, error) {
+	chunks, err := graphql.FetchChunks(ctx, client, endTimeMs, durationMs, func(ctx context.Context, w graphql.Window) ([]trip, error) {
+		req := graphql.Request{
+			Query: tripsQueryDoc,
+			Variables: graphql.Variables{
+				"deviceId": deviceID,
+				"endTime":  w.EndMs,
+				"duration": w.DurationMs,
+			},
+		}
+		data, err := graphql.Execute[tripData](ctx, client, req)
+		return data.Device.VehicleActivityReport.TripEntries, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("querying trips: %w", err)
+	}
+	return mergeTrips(client.Chunking.Split(endTimeMs, durationMs), chunks), nil
+}
+
+// Joins the trips of consecutive chunks into what one query over the whole window returns, see graphql.JoinChunks
+func mergeTrips(windows []graphql.Window, chunks [][]trip) []trip {
+	return graphql.JoinChunks(windows, chunks, trip.span, trip.joined)
+}
+
+func (t trip) span() (int, int) {
+	return t.Start.Time, t.End.Time
+}
+
+// The trip from t's start to the end of next
+func (t trip) joined(next trip) trip {
+	t.End = next.End
+	return t
+}
+
+// Works out how much of each trip's driving inside the window was spent in the Recording state
+func recordingCoverage(data recordData, trips []trip, startTimeMs, endTimeMs int) coverage {
+	endTimeMs = min(endTimeMs, int(time.Now().UnixMilli()))
+	var recording []interval
+	for _, seg := range stateSegments(data, startTimeMs, endTimeMs) {
+		if seg.state == stateRecording {
+			recording = append(recording, interval{seg.startTime, seg.endTime})
+		}
+	}
+	var cov coverage
+	for _, t := range trips {
+		driving := interval{max(t.Start.Time, startTimeMs), min(t.End.Time, endTimeMs)}
+		if driving.end <= driving.start {
+			continue
+		}
+		tc := tripCoverage{trip: t, driving: driving}
+		cursor := driving.start // Driving before cursor is accounted for
+		for _, r := range recording {
+			if r.end <= cursor || r.start >= driving.end {
+				continue
+			}
+			if r.start > cursor {
+				tc.unrecorded = append(tc.unrecorded, interval{cursor, r.start})
+			}
+			overlapEnd := min(r.end, driving.end)
+			tc.recordedMs += overlapEnd - max(r.start, cursor)
+			cursor = overlapEnd
+		}
+		if cursor < driving.end {
+			tc.unrecorded = append(tc.unrecorded, interval{cursor, driving.end})
+		}
+		cov.trips = append(cov.trips, tc)
+		cov.drivingMs += driving.end - driving.start
+		cov.recordedMs += tc.recordedMs
+	}
+	return cov
+}
+
+// Prints each trip with the share of its driving recorded and the stretches that were not, then the overall share
+func printCoverage(w io.Writer, cov coverage, loc *time.Location) {
+	at := func(ms int) string {
+		return time.Unix(int64(ms/1000), 0).In(loc).Format(time.DateTime)
+	}
+	fmt.Fprintln(w, " Recording coverage of driving time:")
+	if len(cov.trips) == 0 {
+		fmt.Fprint(w, "   No trips in the window\n\n")
+		return
+	}
+	for _, tc := range cov.trips {
+		fmt.Fprintf(w, "   Trip %s to %s (%s to %s): %s driving, %s recorded\n", at(tc.driving.start), at(tc.driving.end),
+			orUnknown(tc.trip.Start.Address.Name), orUnknown(tc.trip.End.Address.Name),
+			secToHours((tc.driving.end-tc.driving.start)/1000), percent(tc.recordedMs, tc.driving.end-tc.driving.start))
+		for _, gap := range tc.unrecorded {
+			fmt.Fprintf(w, "     Not recorded: %s to %s (%s)\n", at(gap.start), at(gap.end), secToHours((gap.end-gap.start)/1000))
+		}
+	}
+	fmt.Fprintf(w, "   Overall: %s driving, %s recorded\n\n", secToHours(cov.drivingMs/1000), percent(cov.recordedMs, cov.drivingMs))
+}
+
+func orUnknown(name string) string {
+	if name == "" {
+		return "unknown address"
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+func trips(spans ...[2]int) []trip {
+	var out []trip
+	for _, s := range spans {
+		out = append(out, trip{Start: tripPoint{Time: s[0]}, End: tripPoint{Time: s[1]}})
+	}
+	return out
+}
+
+func TestRecordingCoverage(t *testing.T) {
+	// No known state before 2000, recording 2000-5000, stopped 5000-6000, recording 6000 on
+	data := stats([2]int{2000, 1}, [2]int{5000, 3}, [2]int{6000, 1})
+	cov := recordingCoverage(data, trips([2]int{0, 500}, [2]int{1000, 7000}, [2]int{8000, 9000}, [2]int{9500, 20000}), 800, 10000)
+
+	// The first trip ends before the window, the last is cut at its end
+	if len(cov.trips) != 3 {
+		t.Fatalf("Expected 3 trips in the window, got: %+v", cov.trips)
+	}
+	want := []tripCoverage{
+		{driving: interval{1000, 7000}, recordedMs: 4000, unrecorded: []interval{{1000, 2000}, {5000, 6000}}},
+		{driving: interval{8000, 9000}, recordedMs: 1000},
+		{driving: interval{9500, 10000}, recordedMs: 500},
+	}
+	for i, w := range want {
+		got := cov.trips[i]
+		if got.driving != w.driving || got.recordedMs != w.recordedMs || !reflect.DeepEqual(got.unrecorded, w.unrecorded) {
+			t.Errorf("Trip %d: got %+v, want %+v", i, got, w)
+		}
+	}
+	if cov.drivingMs != 7500 || cov.recordedMs != 5500 {
+		t.Errorf("Overall: got %d of %d ms, want 5500 of 7500", cov.recordedMs, cov.drivingMs)
+	}
+}
+
+func TestMergeTrips(t *testing.T) {
+	// The same trip from two chunks, a trip cut at the chunk boundary 5000, and two back-to-back trips
+	// at 2000 and 7500 that are not at a boundary and stay apart
+	windows := []graphql.Window{{EndMs: 5000, DurationMs: 4000}, {EndMs: 9000, DurationMs: 4000}}
+	merged := mergeTrips(windows, [][]trip{
+		trips([2]int{1000, 2000}, [2]int{2000, 3000}, [2]int{4000, 5000}),
+		trips([2]int{5000, 6000}, [2]int{7000, 7500}, [2]int{7500, 8000}),
+	})
+	want := trips([2]int{1000, 2000}, [2]int{2000, 3000}, [2]int{4000, 6000}, [2]int{7000, 7500}, [2]int{7500, 8000})
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %+v, want %+v", merged, want)
+	}
+	merged = mergeTrips(windows, [][]trip{
+		trips([2]int{1000, 2000}, [2]int{4500, 5500}),
+		trips([2]int{4500, 5500}, [2]int{7000, 8000}),
+	})
+	if want := trips([2]int{1000, 2000}, [2]int{4500, 5500}, [2]int{7000, 8000}); !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %+v, want %+v", merged, want)
+	}
+}
+
+func TestRecordingCoverageFixture(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+		Chunking: graphql.Chunking{Size: 10 * time.Minute, Concurrency: 4},
+	})
+	endTime, duration := 1541168971265, 3123000
+	data, err := recordingQuery(context.Background(), client, 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	found, err := tripsQuery(context.Background(), client, 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// Both trips span a chunk boundary and come back once each
+	if len(found) != 2 || found[0].Start.Address.Name != "Owl HQ" || found[1].End.Address.Name != "San Jose Depot" {
+		t.Fatalf("Expected Truck 1's 2 trips, got: %+v", found)
+	}
+	cov := recordingCoverage(data, found, endTime-duration, endTime)
+	// The camera was on but not recording for the first 8.265s of the first trip
+	if cov.drivingMs != 1400000 || cov.recordedMs != 1391735 {
+		t.Errorf("Overall: got %d of %d ms, want 1391735 of 1400000", cov.recordedMs, cov.drivingMs)
+	}
+	if gaps := cov.trips[0].unrecorded; len(gaps) != 1 || gaps[0] != (interval{1541165900000, 1541165908265}) {
+		t.Errorf("Expected one unrecorded gap at the start of the first trip, got: %+v", gaps)
+	}
+
+	var out strings.Builder
+	printCoverage(&out, cov, time.UTC)
+	for _, line := range []string{
+		"Trip 2018-11-02 13:38:20 to 2018-11-02 13:48:20 (Owl HQ to Oakland Yard): 10m 0s driving, 98.6% recorded",
+		"Not recorded: 2018-11-02 13:38:20 to 2018-11-02 13:38:28 (0m 8s)",
+		"(Oakland Yard to San Jose Depot): 13m 20s driving, 100.0% recorded",
+		"Overall: 23m 20s driving, 99.4% recorded",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Coverage is missing %q:\n%s", line, out.String())
+		}
+	}
+
+	out.Reset()
+	writeRecordingJSON(&out, 212014918236538, parseRecording(data, endTime-duration, endTime), &cov, nil, data, endTime-duration, endTime, time.UTC)
+	var report recordingJSON
+	if err := json.Unmarshal([]byte(out.String()), &report); err != nil {
+		t.Fatalf("Output is not JSON: %s", err)
+	}
+	c := report.Coverage
+	if c == nil || c.DrivingMs != 1400000 || *c.Percent != 99.4 || len(c.Trips) != 2 || *c.Trips[0].Percent != 98.6 || c.Trips[0].From != "Owl HQ" {
+		t.Fatalf("Unexpected coverage: %s", out.String())
+	}
+	if gap := c.Trips[0].Unrecorded; len(gap) != 1 || gap[0].DurationMs != 8265 || len(c.Trips[1].Unrecorded) != 0 {
+		t.Errorf("Unexpected unrecorded intervals: %+v %+v", gap, c.Trips[1].Unrecorded)
+	}
+}
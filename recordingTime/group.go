@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// How many of the vehicles that recorded least are listed under the group table
+const worstOffenders = 5
+
+// Query for the name and devices of a group
+const groupDevicesQueryDoc = `query groupDevices($groupId: Int64!) {
+	group(id: $groupId) {
+		name
+		devices {
+			id
+			name
+		}
+	}
+}`
+
+// The dashcam state changes of one device over a window, batched under aliases with other devices'
+var recordingBatch = graphql.Batch{
+	Name: "deviceRecording",
+	Field: `device(id: $deviceId) {
+		name
+		objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) {
+			changedAtMs
+			intValue
+		}
+	}`,
+	Types: map[string]string{"deviceId": "Int64!", "endTime": "Int64!", "duration": "Int64!"},
+}
+
+type groupList struct {
+	Group struct {
+		Name    string
+		Devices []groupDevice
+	}
+}
+
+type groupDevice struct {
+	ID   int `json:"id"`
+	Name string
+}
+
+// vehicleRecording - The recording breakdown of one vehicle of a group
+type vehicleRecording struct {
+	id      int
+	name    string
+	records cameraRecordElements
+	camera  bool  // False when the device has no dashcam state at all, e.g. no camera is fitted
+	err     error // Set when the vehicle could not be fetched, it is then left out of the totals
+}
+
+// groupReport - The recording breakdown of every vehicle of a group over a window
+type groupReport struct {
+	name     string
+	vehicles []vehicleRecording // In the order the group lists them
+	windowMs int                // Length of the window up to now, what a vehicle recording throughout would total
+}
+
+// Fetches the recording data of every device of a group, packed into batched requests of which up to workers
+// run at a time, and totals each device's states. A device that cannot be fetched is kept in the report with
+// its error instead of failing it.
+func groupRecording(ctx context.Context, client *graphql.Client, groupID, endTimeMs, durationMs, workers int) (groupReport, error) {
+	list, err := graphql.Execute[groupList](ctx, client, graphql.Request{
+		Query:     groupDevicesQueryDoc,
+		Variables: graphql.Variables{"groupId": groupID},
+	})
+	if err != nil {
+		return groupReport{}, fmt.Errorf("listing the group's vehicles: %w", err)
+	}
+	listed := list.Group.Devices
+	startTimeMs := endTimeMs - durationMs
+	report := groupReport{
+		name:     list.Group.Name,
+		vehicles: make([]vehicleRecording, len(listed)),
+		windowMs: min(endTimeMs, int(time.Now().UnixMilli())) - startTimeMs,
+	}
+
+	size := client.Batching.Size
+	if size <= 0 {
+		size = graphql.DefaultBatching.Size
+	}
+	slots := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for start := 0; start < len(listed); start += size {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		batch := listed[start:min(start+size, len(listed))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			ids := make([]int, len(batch))
+			for i, d := range batch {
+				ids[i] = d.ID
+			}
+			data, errs := batchRecording(ctx, client, ids, endTimeMs, durationMs)
+			for i, d := range batch {
+				v := vehicleRecording{id: d.ID, name: d.Name, err: errs[i]}
+				if v.err == nil {
+					v.camera = len(data[i].Device.ObjectStat) > 0
+					v.records = parseRecording(data[i], startTimeMs, endTimeMs)
+				}
+				report.vehicles[start+i] = v
+			}
+		}()
+	}
+	wg.Wait()
+	return report, ctx.Err()
+}
+
+// Fetches the recording data of several devices with batched queries, in chunks when the window is long,
+// with the change each window starts in first as recordingQuery does. Returns the data and error of each
+// device, in the order given. Partial data fails the device, as its totals would be wrong.
+func batchRecording(ctx context.Context, client *graphql.Client, ids []int, endTimeMs, durationMs int) ([]recordData, []error) {
+	items := make([]graphql.Variables, len(ids))
+	for i, id := range ids {
+		items[i] = graphql.Variables{"deviceId": id}
+	}
+	chunks, chunkErrs := graphql.ExecuteBatchWindows[device](ctx, client, recordingBatch, items, client.Chunking.Split(endTimeMs, durationMs))
+
+	data := make([]recordData, len(ids))
+	errs := make([]error, len(ids))
+	var lookup []int // Devices whose state at the start of the window is still to be found
+	for i := range ids {
+		if chunkErrs[i] != nil {
+			errs[i] = fmt.Errorf("querying recording data: %w", chunkErrs[i])
+			continue
+		}
+		deviceChunks := make([]recordData, len(chunks[i]))
+		for c, d := range chunks[i] {
+			deviceChunks[c] = recordData{Device: d}
+		}
+		data[i] = mergeRecording(deviceChunks)
+		if stats := data[i].Device.ObjectStat; len(stats) == 0 || stats[0].ChangedAtMs != endTimeMs-durationMs {
+			lookup = append(lookup, i)
+		}
+	}
+	if len(lookup) == 0 {
+		return data, errs
+	}
+
+	lookupIDs := make([]int, len(lookup))
+	for j, i := range lookup {
+		lookupIDs[j] = ids[i]
+	}
+	initial, found, initialErrs := initialStates(ctx, client, lookupIDs, endTimeMs-durationMs)
+	for j, i := range lookup {
+		switch {
+		case initialErrs[j] != nil:
+			errs[i] = fmt.Errorf("querying the dashcam state before the window: %w", initialErrs[j])
+		case found[j]:
+			data[i].Device.ObjectStat = append([]recordOS{initial[j]}, data[i].Device.ObjectStat...)
+		}
+	}
+	return data, errs
+}
+
+// Prints the per-vehicle table, the fleet totals and average, and the vehicles that recorded least
+func printGroupReport(w io.Writer, report groupReport, startTimeMs, endTimeMs int, loc *time.Location) {
+	at := func(ms int) time.Time {
+		return time.Unix(int64(ms/1000), 0).In(loc)
+	}
+	fmt.Fprintf(w, "\n\nGroup Name: %s\nFrom %s to %s\n\n", report.name, at(startTimeMs), at(endTimeMs))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "Vehicle")
+	for _, state := range dashcamStates {
+		fmt.Fprintf(tw, "\t%s", state)
+	}
+	fmt.Fprintln(tw, "\tRecorded")
+	var cameras, noCamera, failed []vehicleRecording
+	for _, v := range report.vehicles {
+		switch {
+		case v.err != nil:
+			failed = append(failed, v)
+		case !v.camera:
+			noCamera = append(noCamera, v)
+		default:
+			cameras = append(cameras, v)
+			fmt.Fprint(tw, v.name)
+			for _, state := range dashcamStates {
+				fmt.Fprintf(tw, "\t%s", secToHours(v.records.stateTotals[state]/1000))
+			}
+			fmt.Fprintf(tw, "\t%s\n", percent(v.records.totalRecord, report.windowMs))
+		}
+	}
+	tw.Flush()
+
+	total := 0
+	for _, v := range cameras {
+		total += v.records.totalRecord
+	}
+	fmt.Fprintf(w, "\nVehicles with a dashcam: %d\n", len(cameras))
+	fmt.Fprintf(w, "Fleet total recording time: %s\n", secToHours(total/1000))
+	if len(cameras) > 0 {
+		average := total / len(cameras)
+		fmt.Fprintf(w, "Fleet average recording time: %s per vehicle (%s of the window)\n", secToHours(average/1000), percent(average, report.windowMs))
+
+		worst := append([]vehicleRecording(nil), cameras...)
+		sort.SliceStable(worst, func(i, j int) bool { return worst[i].records.totalRecord < worst[j].records.totalRecord })
+		fmt.Fprintln(w, "\nRecorded least:")
+		for i, v := range worst[:min(worstOffenders, len(worst))] {
+			fmt.Fprintf(w, "  %d. %s  %s (%s)\n", i+1, v.name, secToHours(v.records.totalRecord/1000), percent(v.records.totalRecord, report.windowMs))
+		}
+	}
+	if len(noCamera) > 0 {
+		fmt.Fprintln(w, "\nNo dashcam data, left out of the totals:")
+		for _, v := range noCamera {
+			fmt.Fprintf(w, "  %s (%d)\n", v.name, v.id)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(w, "\nCould not be fetched, left out of the totals:")
+		for _, v := range failed {
+			fmt.Fprintf(w, "  %s (%d): %s\n", v.name, v.id, v.err)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func percent(part, whole int) string {
+	if whole <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(part)/float64(whole))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+// The bundled fixtures plus a van without a camera and a van whose lookups fail, both in the test group
+func groupFixtures(t *testing.T) mock.Data {
+	data, err := mock.DefaultFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, _ := data["devices"].([]interface{})
+	data["devices"] = append(devices,
+		map[string]interface{}{"id": 212014918000002.0, "name": "Van 3", "groupId": 4656.0},
+		map[string]interface{}{"id": 212014918000003.0, "name": "Van 4", "groupId": 4656.0, "osDDashcamState": []interface{}{
+			map[string]interface{}{"changedAtMs": 1541165000000.0, "intValue": 1.0},
+		}},
+	)
+	return data
+}
+
+func TestGroupRecording(t *testing.T) {
+	server := httptest.NewServer(mock.NewServer(groupFixtures(t), mock.Options{FailDevices: []int64{212014918000003}}))
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+	})
+	endTime, duration := 1541168971265, 3123000
+
+	report, err := groupRecording(context.Background(), client, 4656, endTime, duration, 4)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// The list, one batch for the window and a day of look back at a time, all but the first only for
+	// Van 3 that has no state to find
+	if got := client.Stats().Requests; got != 2+maxLookbackDays {
+		t.Errorf("Expected %d requests, got %d", 2+maxLookbackDays, got)
+	}
+	if report.name != "Owl Test Group" || len(report.vehicles) != 4 || report.windowMs != duration {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	want := []struct {
+		name      string
+		recording int
+		camera    bool
+		failed    bool
+	}{
+		{"Truck 1", 2459150, true, false},  // Same as the single device report
+		{"Truck 2", duration, true, false}, // Recording since before the window
+		{"Van 3", 0, false, false},
+		{"Van 4", 0, false, true},
+	}
+	for i, w := range want {
+		v := report.vehicles[i]
+		if v.name != w.name || v.records.totalRecord != w.recording || v.camera != w.camera || (v.err != nil) != w.failed {
+			t.Errorf("Vehicle %d: got %s %d ms camera %v err %v, want %+v", i, v.name, v.records.totalRecord, v.camera, v.err, w)
+		}
+	}
+
+	var out strings.Builder
+	printGroupReport(&out, report, endTime-duration, endTime, time.UTC)
+	for _, line := range []string{
+		"Group Name: Owl Test Group",
+		"Vehicles with a dashcam: 2",
+		"Fleet total recording time: 1h 33m",
+		"Fleet average recording time: 46m 31s per vehicle (89.4% of the window)",
+		"  1. Truck 1  40m 59s (78.7%)",
+		"  2. Truck 2  52m 3s (100.0%)",
+		"No dashcam data, left out of the totals:\n  Van 3 (212014918000002)",
+		"Could not be fetched, left out of the totals:\n  Van 4 (212014918000003): ",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Report is missing %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestGroupRecordingChunked(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	endTime, duration := 1541168971265, 3123000
+	whole, err := groupRecording(context.Background(), graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path}), 4656, endTime, duration, 1)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// Ten minute chunks and one device per request
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+		Chunking: graphql.Chunking{Size: 10 * time.Minute, Concurrency: 4},
+		Batching: graphql.Batching{Size: 1},
+	})
+	chunked, err := groupRecording(context.Background(), client, 4656, endTime, duration, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	for i, v := range chunked.vehicles {
+		if v.err != nil || v.records.totalRecord != whole.vehicles[i].records.totalRecord {
+			t.Errorf("%s: chunked total %d differs from %d (%v)", v.name, v.records.totalRecord, whole.vehicles[i].records.totalRecord, v.err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// Status column values of the --batch results
+const (
+	jobOK        = "ok"
+	jobError     = "error"
+	jobCancelled = "cancelled" // Not run, Ctrl-C or --deadline stopped the batch first
+)
+
+// job - One row of a --batch file: a device, a window and an optional label to recognise it by
+type job struct {
+	line     int // Line of the file, for pointing at a bad row
+	label    string
+	deviceID string // As written, so a bad row is echoed back unchanged
+	start    string
+	end      string
+	err      error // Why the row cannot be run, e.g. a device ID that is not a number, or in a jobResult why it failed
+}
+
+// jobResult - What running a job gave
+type jobResult struct {
+	job
+	status  string
+	data    recordData
+	records cameraRecordElements
+}
+
+// Reads the jobs of a --batch CSV file with the columns deviceID, start, end and an optional label.
+// A header row is skipped, and blank lines and lines starting with # are ignored. A row that cannot be
+// run keeps its error, so it is reported in the results rather than stopping the batch.
+func readJobs(r io.Reader) ([]job, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	var jobs []job
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return jobs, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			jobs = append(jobs, job{line: parseErr.Line, err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(jobs) == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "deviceID") {
+			continue
+		}
+		j := job{line: line}
+		switch len(record) {
+		case 4:
+			j.label = record[3]
+			fallthrough
+		case 3:
+			j.deviceID, j.start, j.end = record[0], record[1], record[2]
+		default:
+			j.err = fmt.Errorf("expected deviceID, start, end and an optional label, got %d columns", len(record))
+		}
+		jobs = append(jobs, j)
+	}
+}
+
+// Parses a job's columns the way the command line arguments are parsed
+func (j job) window(now time.Time) (deviceID, startTimeMs, endTimeMs int, err error) {
+	if j.err != nil {
+		return 0, 0, 0, j.err
+	}
+	if deviceID, err = strconv.Atoi(strings.TrimSpace(j.deviceID)); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid deviceID %q", j.deviceID)
+	}
+	if startTimeMs, err = strconv.Atoi(strings.TrimSpace(j.start)); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid start %q", j.start)
+	}
+	if endTimeMs, _, err = parseEndTime(strings.TrimSpace(j.end), now); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid end %q, use a time in ms or now", j.end)
+	}
+	if startTimeMs >= endTimeMs {
+		return 0, 0, 0, errors.New("start is at or after end")
+	}
+	return deviceID, startTimeMs, endTimeMs, nil
+}
+
+// Runs the jobs, up to parallel at a time, and returns their results in the order given. A failing job only
+// fails its own row. Jobs not started when ctx is cancelled are marked cancelled.
+func runJobs(ctx context.Context, client *graphql.Client, jobs []job, parallel int) []jobResult {
+	results := make([]jobResult, len(jobs))
+	for i, j := range jobs {
+		results[i] = jobResult{job: j, status: jobCancelled}
+	}
+	slots := make(chan struct{}, max(parallel, 1))
+	var wg sync.WaitGroup
+	for i := range jobs {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			results[i] = runJob(ctx, client, jobs[i])
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runJob(ctx context.Context, client *graphql.Client, j job) jobResult {
+	result := jobResult{job: j, status: jobError}
+	deviceID, startTimeMs, endTimeMs, err := j.window(time.Now())
+	if err != nil {
+		result.err = err
+		return result
+	}
+	data, err := recordingQuery(ctx, client, deviceID, endTimeMs, endTimeMs-startTimeMs)
+	if ctx.Err() != nil {
+		result.status, result.err = jobCancelled, nil
+		return result
+	}
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.status, result.data = jobOK, data
+	result.records = parseRecording(data, startTimeMs, endTimeMs)
+	return result
+}
+
+// Writes one CSV row per job with its status, the vehicle, the time spent in each state in ms and any error
+func writeJobResults(w io.Writer, results []jobResult) error {
+	out := csv.NewWriter(w)
+	header := []string{"line", "label", "deviceID", "start", "end", "status", "vehicle", "group"}
+	for _, state := range dashcamStates {
+		header = append(header, state.key()+"Ms")
+	}
+	header = append(header, "unknownMs", "error")
+	out.Write(header)
+	for _, r := range results {
+		row := []string{strconv.Itoa(r.line), r.label, r.deviceID, r.start, r.end, r.status}
+		if r.status == jobOK {
+			row = append(row, r.data.Device.DeviceName, r.data.Device.Group.Name)
+			for _, state := range dashcamStates {
+				row = append(row, strconv.Itoa(r.records.stateTotals[state]))
+			}
+			unknown := 0
+			for _, u := range r.records.unknown {
+				unknown += u.duration
+			}
+			row = append(row, strconv.Itoa(unknown), "")
+		} else {
+			row = append(row, make([]string, 2+len(dashcamStates)+1)...)
+			if r.err != nil {
+				row = append(row, r.err.Error())
+			} else {
+				row = append(row, "")
+			}
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+const jobsCSV = `deviceID,start,end,label
+# Ticket 1234
+212014918236538,1541165848265,1541168971265,Truck 1 afternoon
+212014918137973, 1541166000000, 1541167000000
+
+212014918236538,1541165848265
+truck,1541165848265,1541168971265,not a number
+212014918137973,1541168971265,1541165848265,backwards
+212014918000099,1541165848265,1541168971265,missing
+`
+
+func TestReadJobs(t *testing.T) {
+	jobs, err := readJobs(strings.NewReader(jobsCSV))
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if len(jobs) != 6 {
+		t.Fatalf("Expected 6 jobs without the header, comment and blank line, got %d: %+v", len(jobs), jobs)
+	}
+	if j := jobs[0]; j.line != 3 || j.label != "Truck 1 afternoon" || j.deviceID != "212014918236538" || j.err != nil {
+		t.Errorf("First job was not read, got: %+v", j)
+	}
+	if j := jobs[1]; j.label != "" || j.start != "1541166000000" || j.err != nil {
+		t.Errorf("Expected a job without a label, got: %+v", j)
+	}
+	if jobs[2].err == nil || jobs[2].line != 6 {
+		t.Errorf("Expected a column count error on line 6, got: %+v", jobs[2])
+	}
+}
+
+func TestRunJobs(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+	})
+	jobs, _ := readJobs(strings.NewReader(jobsCSV))
+	results := runJobs(context.Background(), client, jobs, 3)
+
+	var out strings.Builder
+	if err := writeJobResults(&out, results); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("Results are not valid CSV: %s\n%s", err, out.String())
+	}
+	if len(rows) != 7 {
+		t.Fatalf("Expected a header and 6 rows, got:\n%s", out.String())
+	}
+	column := map[string]int{}
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+	// Durations are all in ms for the tools reading the results, recordingMs already holds the recording time
+	if _, ok := column["recording"]; ok {
+		t.Errorf("Unexpected human readable recording column: %v", rows[0])
+	}
+	want := []struct {
+		status, recordingMs, err string
+	}{
+		{"ok", "2459150", ""},
+		{"ok", "1000000", ""},
+		{"error", "", "expected deviceID, start, end"},
+		{"error", "", `invalid deviceID "truck"`},
+		{"error", "", "start is at or after end"},
+		{"error", "", "device 212014918000099 not found"},
+	}
+	for i, w := range want {
+		row := rows[i+1]
+		if row[column["status"]] != w.status || row[column["recordingMs"]] != w.recordingMs || !strings.Contains(row[column["error"]], w.err) {
+			t.Errorf("Row %d: got %v, want %+v", i+1, row, w)
+		}
+	}
+	if rows[1][column["label"]] != "Truck 1 afternoon" || rows[1][column["vehicle"]] != "Truck 1" || rows[1][column["group"]] != "Owl Test Group" {
+		t.Errorf("Expected the label and vehicle in the first row, got: %v", rows[1])
+	}
+}
+
+func TestRunJobsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	jobs, _ := readJobs(strings.NewReader(jobsCSV))
+	for _, r := range runJobs(ctx, graphql.NewClient(graphql.Options{Endpoint: "http://127.0.0.1:1"}), jobs, 2) {
+		if r.status != jobCancelled {
+			t.Errorf("Expected every job to be cancelled, got: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Version of the --format json schema, raised whenever a field is renamed or removed. New fields may be
+// added without raising it.
+const schemaVersion = 1
+
+// ISO-8601 with milliseconds and the UTC offset of the configured timezone
+const isoMillis = "2006-01-02T15:04:05.000Z07:00"
+
+// recordingJSON - The --format json report, see the README for the schema
+type recordingJSON struct {
+	SchemaVersion    int            `json:"schemaVersion"`
+	Device           deviceJSON     `json:"device"`
+	Window           spanJSON       `json:"window"`
+	Segments         []spanJSON     `json:"segments"` // Recording segments, oldest first
+	TotalRecordingMs int            `json:"totalRecordingMs"`
+	States           map[string]int `json:"states"` // Milliseconds in each state by dashcamState.key
+	UnknownStates    []unknownJSON  `json:"unknownStates"`
+	Coverage         *coverageJSON  `json:"coverage"` // Null when the trips could not be fetched
+	Buckets          []bucketJSON   `json:"buckets"`  // Null without --bucket
+}
+
+// bucketJSON - The time in each state during one calendar bucket
+type bucketJSON struct {
+	spanJSON
+	Label       string         `json:"label"`
+	RecordingMs int            `json:"recordingMs"`
+	States      map[string]int `json:"states"`
+	UnknownMs   int            `json:"unknownMs"`
+}
+
+type deviceJSON struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Group string `json:"group"`
+}
+
+// spanJSON - A stretch of time in ms since the epoch and as ISO-8601
+type spanJSON struct {
+	StartMs    int    `json:"startMs"`
+	Start      string `json:"start"`
+	EndMs      int    `json:"endMs"`
+	End        string `json:"end"`
+	DurationMs int    `json:"durationMs"`
+}
+
+// coverageJSON - The share of driving time recorded, overall and per trip
+type coverageJSON struct {
+	DrivingMs  int        `json:"drivingMs"`
+	RecordedMs int        `json:"recordedMs"`
+	Percent    *float64   `json:"percent"` // Null without driving
+	Trips      []tripJSON `json:"trips"`
+}
+
+type tripJSON struct {
+	Driving    spanJSON   `json:"driving"` // The part of the trip inside the window
+	From       string     `json:"from"`
+	To         string     `json:"to"`
+	RecordedMs int        `json:"recordedMs"`
+	Percent    *float64   `json:"percent"`
+	Unrecorded []spanJSON `json:"unrecorded"`
+}
+
+type unknownJSON struct {
+	Value      int `json:"value"`
+	Changes    int `json:"changes"`
+	DurationMs int `json:"durationMs"`
+}
+
+func span(startMs, endMs int, loc *time.Location) spanJSON {
+	iso := func(ms int) string {
+		return time.UnixMilli(int64(ms)).In(loc).Format(isoMillis)
+	}
+	return spanJSON{startMs, iso(startMs), endMs, iso(endMs), endMs - startMs}
+}
+
+// Writes the report as one indented JSON object
+func writeRecordingJSON(w io.Writer, deviceID int, records cameraRecordElements, driving *coverage, buckets []bucket, data recordData, startTimeMs, endTimeMs int, loc *time.Location) error {
+	report := recordingJSON{
+		SchemaVersion:    schemaVersion,
+		Device:           deviceJSON{deviceID, data.Device.DeviceName, data.Device.Group.Name},
+		Window:           span(startTimeMs, endTimeMs, loc),
+		Segments:         []spanJSON{},
+		TotalRecordingMs: records.totalRecord,
+		States:           map[string]int{},
+		UnknownStates:    []unknownJSON{},
+	}
+	for _, r := range records.cameraElement {
+		report.Segments = append(report.Segments, span(r.startTime, r.endTime, loc))
+	}
+	for _, state := range dashcamStates {
+		report.States[state.key()] = records.stateTotals[state]
+	}
+	for _, u := range records.unknown {
+		report.UnknownStates = append(report.UnknownStates, unknownJSON{u.value, u.changes, u.duration})
+	}
+	if driving != nil {
+		report.Coverage = &coverageJSON{
+			DrivingMs:  driving.drivingMs,
+			RecordedMs: driving.recordedMs,
+			Percent:    ratio(driving.recordedMs, driving.drivingMs),
+			Trips:      []tripJSON{},
+		}
+		for _, tc := range driving.trips {
+			t := tripJSON{
+				Driving:    span(tc.driving.start, tc.driving.end, loc),
+				From:       tc.trip.Start.Address.Name,
+				To:         tc.trip.End.Address.Name,
+				RecordedMs: tc.recordedMs,
+				Percent:    ratio(tc.recordedMs, tc.driving.end-tc.driving.start),
+				Unrecorded: []spanJSON{},
+			}
+			for _, gap := range tc.unrecorded {
+				t.Unrecorded = append(t.Unrecorded, span(gap.start, gap.end, loc))
+			}
+			report.Coverage.Trips = append(report.Coverage.Trips, t)
+		}
+	}
+	if buckets != nil {
+		report.Buckets = []bucketJSON{}
+	}
+	for _, b := range buckets {
+		bj := bucketJSON{span(b.start, b.end, loc), b.label, b.recording, map[string]int{}, b.unknownMs}
+		for _, state := range dashcamStates {
+			bj.States[state.key()] = b.stateTotals[state]
+		}
+		report.Buckets = append(report.Buckets, bj)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// Writes one CSV row per recording segment, the total being the sum of durationMs
+func writeRecordingCSV(w io.Writer, deviceID int, records cameraRecordElements, data recordData, loc *time.Location) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"deviceId", "vehicle", "group", "startMs", "start", "endMs", "end", "durationMs"})
+	for _, r := range records.cameraElement {
+		s := span(r.startTime, r.endTime, loc)
+		out.Write([]string{strconv.Itoa(deviceID), data.Device.DeviceName, data.Device.Group.Name,
+			strconv.Itoa(s.StartMs), s.Start, strconv.Itoa(s.EndMs), s.End, strconv.Itoa(s.DurationMs)})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Writes one CSV row per --bucket bucket with the ms spent in each state
+func writeBucketsCSV(w io.Writer, deviceID int, buckets []bucket, data recordData, loc *time.Location) error {
+	out := csv.NewWriter(w)
+	header := []string{"deviceId", "vehicle", "group", "bucket", "startMs", "start", "endMs", "end", "durationMs"}
+	for _, state := range dashcamStates {
+		header = append(header, state.key()+"Ms")
+	}
+	out.Write(append(header, "unknownMs"))
+	for _, b := range buckets {
+		s := span(b.start, b.end, loc)
+		row := []string{strconv.Itoa(deviceID), data.Device.DeviceName, data.Device.Group.Name, b.label,
+			strconv.Itoa(s.StartMs), s.Start, strconv.Itoa(s.EndMs), s.End, strconv.Itoa(s.DurationMs)}
+		for _, state := range dashcamStates {
+			row = append(row, strconv.Itoa(b.stateTotals[state]))
+		}
+		out.Write(append(row, strconv.Itoa(b.unknownMs)))
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Percentage of part in whole rounded to a tenth, nil when whole is empty
+func ratio(part, whole int) *float64 {
+	if whole <= 0 {
+		return nil
+	}
+	p := math.Round(1000*float64(part)/float64(whole)) / 10
+	return &p
+}
+
+// Checks a --format value
+func checkFormat(format string) error {
+	switch format {
+	case "text", "json", "csv":
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, use text, json or csv", format)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteRecordingJSON(t *testing.T) {
+	data := stats([2]int{1000, 1}, [2]int{61000, 9}, [2]int{62000, 1})
+	data.Device.DeviceName, data.Device.Group.Name = "Truck 1", "Owl Test Group"
+	records := parseRecording(data, 0, 120000)
+	loc := time.FixedZone("CST", -6*3600)
+
+	var out strings.Builder
+	if err := writeRecordingJSON(&out, 212014918236538, records, nil, nil, data, 0, 120000, loc); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// The top level keys are the documented schema, scripts rely on them
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(out.String()), &raw); err != nil {
+		t.Fatalf("Output is not JSON: %s\n%s", err, out.String())
+	}
+	var keys []string
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if want := []string{"buckets", "coverage", "device", "schemaVersion", "segments", "states", "totalRecordingMs", "unknownStates", "window"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Schema keys changed, got %v, want %v", keys, want)
+	}
+
+	var report recordingJSON
+	json.Unmarshal([]byte(out.String()), &report)
+	if report.SchemaVersion != 1 || report.Device != (deviceJSON{212014918236538, "Truck 1", "Owl Test Group"}) {
+		t.Errorf("Unexpected header: %+v", report)
+	}
+	wantWindow := spanJSON{0, "1969-12-31T18:00:00.000-06:00", 120000, "1969-12-31T18:02:00.000-06:00", 120000}
+	if report.Window != wantWindow {
+		t.Errorf("Window: got %+v, want %+v", report.Window, wantWindow)
+	}
+	wantSegments := []spanJSON{
+		{1000, "1969-12-31T18:00:01.000-06:00", 61000, "1969-12-31T18:01:01.000-06:00", 60000},
+		{62000, "1969-12-31T18:01:02.000-06:00", 120000, "1969-12-31T18:02:00.000-06:00", 58000},
+	}
+	if !reflect.DeepEqual(report.Segments, wantSegments) || report.TotalRecordingMs != 118000 {
+		t.Errorf("Segments: got %+v total %d", report.Segments, report.TotalRecordingMs)
+	}
+	if len(report.States) != len(dashcamStates) || report.States["recording"] != 118000 || report.States["cameraOn"] != 0 {
+		t.Errorf("States: got %+v", report.States)
+	}
+	if !reflect.DeepEqual(report.UnknownStates, []unknownJSON{{9, 1, 1000}}) {
+		t.Errorf("Unknown states: got %+v", report.UnknownStates)
+	}
+
+	// Empty lists stay lists, so scripts need not check for null
+	out.Reset()
+	writeRecordingJSON(&out, 1, parseRecording(recordData{}, 0, 1000), nil, nil, recordData{}, 0, 1000, time.UTC)
+	if !strings.Contains(out.String(), `"segments": []`) || !strings.Contains(out.String(), `"unknownStates": []`) {
+		t.Errorf("Expected empty lists, got:\n%s", out.String())
+	}
+}
+
+func TestWriteRecordingCSV(t *testing.T) {
+	data := stats([2]int{1000, 1}, [2]int{61000, 3}, [2]int{62000, 1})
+	data.Device.DeviceName = "Truck 1"
+	var out strings.Builder
+	if err := writeRecordingCSV(&out, 7, parseRecording(data, 0, 120000), data, time.UTC); err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(out.String())).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("Expected a header and 2 segments, got %v:\n%s", err, out.String())
+	}
+	if want := []string{"7", "Truck 1", "", "1000", "1970-01-01T00:00:01.000Z", "61000", "1970-01-01T00:01:01.000Z", "60000"}; !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("First segment: got %v, want %v", rows[1], want)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for _, f := range []string{"text", "json", "csv"} {
+		if err := checkFormat(f); err != nil {
+			t.Errorf("%s: received an error: %s", f, err)
+		}
+	}
+	if checkFormat("xml") == nil {
+		t.Errorf("Expected an error for xml")
+	}
+}
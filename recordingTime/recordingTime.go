@@ -1,15 +1,17 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"io"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/thewhofan23/OwlCode/cli"
+	"github.com/thewhofan23/OwlCode/graphql"
 )
 
 /*
@@ -21,12 +23,12 @@ Int value decoding
 4: Camera Starting
 5: Camera On
 
-Will just need to find IntValue 1's then look to next status change, take difference. Aggregate the time
+Each value lasts until the next status change, see dashcamState in state.go. Recording time is the time spent in 1.
 
 What we'll input:
 deviceID
 startTimeMs
-endTimeMs (accept some input to mean live, perhaps 0)
+endTimeMs (0 or now for live, see watch.go)
 
 What we'll output:
 
@@ -40,18 +42,6 @@ Total recording time
 
 */
 
-// Structure to hold .json config data
-type config struct {
-	Token   string
-	Timeout int
-}
-
-// Structure to format graphQL queries
-type graphQL struct {
-	Query     string
-	Variables struct{}
-}
-
 // Structures to hold return information from graphQL
 type recordData struct {
 	Device device
@@ -81,178 +71,432 @@ type cameraRecordElement struct {
 type cameraRecordElements struct {
 	cameraElement []cameraRecordElement
 	totalRecord   int
+	stateTotals   map[dashcamState]int // Milliseconds spent in each known state
+	unknown       []unknownState       // States with values missing from dashcamStates, in the order first seen
 }
 
 func main() {
 
-	fmt.Println("\n Welcome to the camera recording time calculator!")
+	var flags cli.Common
+	flags.Register(flag.CommandLine)
+	watch := flag.Duration("watch", 0, "Poll the device this often, e.g. 30s, and keep the current state and total up to date (needs an end time of now)")
+	groupID := flag.Int("group", 0, "Report every vehicle of this group instead of one device")
+	deviceWorkers := flag.Int("device-workers", 8, "Batched requests run at once by --group")
+	jobsFile := flag.String("batch", "", "Run every deviceID,start,end[,label] row of this CSV file instead of one device")
+	output := flag.String("output", "", "File to write the --batch results CSV to instead of stdout")
+	parallel := flag.Int("parallel", 4, "Jobs run at once by --batch")
+	format := flag.String("format", "text", "Write the report as text, json or csv (the single device report only)")
+	bucketName := flag.String("bucket", "", "Also split the time in each state by calendar hour, day or week in the configured timezone")
+	flag.Parse()
+	input := flag.Args()
+	if err := flags.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	input := os.Args
+	// "cache clear" manages the response cache instead of running the report
+	if len(input) > 0 && input[0] == "cache" {
+		if err := flags.CacheCommand(input[1:]); err != nil {
+			fail("Cache command failed", "err", err)
+		}
+		return
+	}
 
-	if len(input) != 4 {
-		fmt.Println("Format Invalid!: Please follow this format: ./recordingTime <deviceID> <startTimeMs> <endTimeMs>")
+	// "doctor" checks the config and the connection to the API instead of running the report
+	if len(input) > 0 && input[0] == "doctor" {
+		ctx, cancel := cli.SignalContext(flags.Deadline)
+		defer cancel()
+		if err := flags.Doctor(ctx, os.Stdout); err != nil {
+			fail("Doctor check failed", "err", err)
+		}
 		return
 	}
 
-	deviceID := input[1]    // e.g. 212014918137973
-	startTimeMs := input[2] // e.g. 1540397854230
-	endTimeMs := input[3]   // e.g. 1540400526230
+	if err := checkFormat(*format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *format != "text" && (*jobsFile != "" || *groupID != 0 || *watch > 0) {
+		fail("--format is for the single device report, --batch always writes CSV and --group and --watch write text")
+	}
+	var buckets *bucketing
+	if *bucketName != "" {
+		b, err := parseBucket(*bucketName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if *jobsFile != "" || *groupID != 0 || *watch > 0 {
+			fail("--bucket is for the single device report")
+		}
+		buckets = &b
+	}
 
-	// Check the inputs to see if they are valid integers
-	_, err := strconv.Atoi(deviceID)
-	if err != nil {
-		fmt.Println("Error: ", err)
+	if *jobsFile != "" {
+		runBatchFile(flags, *jobsFile, *output, *parallel)
 		return
 	}
+
+	args := 3
+	if *groupID != 0 {
+		args = 2
+	}
+	if len(input) != args {
+		fmt.Fprintln(os.Stderr, "Format Invalid!: Please follow this format: ./recordingTime "+cli.Usage+" [--watch 30s] <deviceID> <startTimeMs> <endTimeMs|now>")
+		fmt.Fprintln(os.Stderr, "or, for every vehicle of a group: ./recordingTime "+cli.Usage+" --group <groupID> [--device-workers 8] <startTimeMs> <endTimeMs|now>")
+		fmt.Fprintln(os.Stderr, "or, for every row of a CSV file: ./recordingTime "+cli.Usage+" --batch jobs.csv [--parallel 4] [--output results.csv]")
+		os.Exit(2)
+	}
+	if *groupID != 0 && *watch > 0 {
+		fail("--watch follows a single device and cannot be used with --group")
+	}
+	slog.Debug("Welcome to the camera recording time calculator!")
+
+	var deviceIDInt int
+	var err error
+	if *groupID == 0 {
+		deviceID := input[0] // e.g. 212014918137973
+		// Check the inputs to see if they are valid integers
+		deviceIDInt, err = strconv.Atoi(deviceID)
+		if err != nil {
+			fail("Invalid deviceID", "err", err)
+		}
+	}
+	startTimeMs := input[args-2] // e.g. 1540397854230
+	endTimeMs := input[args-1]   // e.g. 1540400526230, or now
+
 	startTimeMsInt, err := strconv.Atoi(startTimeMs)
 	if err != nil {
-		fmt.Println("Error: ", err)
-		return
+		fail("Invalid startTimeMs", "err", err)
 	}
-	endTimeMsInt, err := strconv.Atoi(endTimeMs)
+	endTimeMsInt, live, err := parseEndTime(endTimeMs, time.Now())
 	if err != nil {
-		fmt.Println("Error: ", err)
-		return
+		fail("Invalid endTimeMs, use a time in ms or now", "err", err)
+	}
+	if *watch > 0 && !live {
+		fail("--watch needs an end time of now (or 0)")
 	}
 	if startTimeMsInt >= endTimeMsInt {
-		fmt.Println("Start time is greater than or equal to end time! Please correct your times.")
+		fail("Start time is greater than or equal to end time! Please correct your times.")
+	}
+
+	conf, err := flags.LoadConfig()
+	if err != nil {
+		fail("Could not load the config", "err", err)
+	}
+	loc, err := conf.Location()
+	if err != nil {
+		fail("Could not load the timezone", "err", err)
+	}
+	client, err := flags.NewClient(conf)
+	if err != nil {
+		fail("Could not create the graphQL client", "err", err)
+	}
+
+	// Ctrl-C or the deadline cancels the query in flight
+	ctx, cancel := cli.SignalContext(flags.Deadline)
+	defer cancel()
+
+	if *groupID != 0 {
+		report, err := groupRecording(ctx, client, *groupID, endTimeMsInt, endTimeMsInt-startTimeMsInt, *deviceWorkers)
+		if ctx.Err() != nil {
+			slog.Warn(cli.Interrupted(ctx) + " before every vehicle was fetched, nothing to report.")
+			return
+		}
+		if err != nil {
+			fail("Could not fetch the group's recording data", "err", err)
+		}
+		printGroupReport(os.Stdout, report, startTimeMsInt, endTimeMsInt, loc)
+		cli.LogStats(client)
+		return
+	}
+
+	if *watch > 0 {
+		watchRecording(ctx, client, deviceIDInt, startTimeMsInt, *watch, os.Stdout, isTerminal(os.Stdout), loc)
+		cli.LogStats(client)
 		return
 	}
 
 	// Query for the recording data from graphQL
-	cameraData, err := recordingQuery(deviceID, endTimeMs, strconv.Itoa(endTimeMsInt-startTimeMsInt))
-	if err != nil {
-		fmt.Println("Error encountered:", err)
+	cameraData, err := recordingQuery(ctx, client, deviceIDInt, endTimeMsInt, endTimeMsInt-startTimeMsInt)
+	if ctx.Err() != nil {
+		slog.Warn(cli.Interrupted(ctx) + " before the recording data was fetched, nothing to report.")
 		return
 	}
+	if err != nil {
+		fail("Could not fetch the recording data", "err", err)
+	}
 	// Parse and calculate the queried data
 	aggregateRecording := parseRecording(cameraData, startTimeMsInt, endTimeMsInt)
+	for _, u := range aggregateRecording.unknown {
+		slog.Warn("Unknown dashcam state, its time is left out of the breakdown", "value", u.value, "changes", u.changes, "duration", secToHours(u.duration/1000))
+	}
+	// The trips give the share of driving time recorded, the report stands without them. The CSV rows have
+	// no place for it, so the trips are only fetched for the text and JSON reports.
+	var driving *coverage
+	if *format != "csv" {
+		trips, tripsErr := tripsQuery(ctx, client, deviceIDInt, endTimeMsInt, endTimeMsInt-startTimeMsInt)
+		if tripsErr != nil {
+			slog.Warn("Could not fetch the trips, leaving the driving coverage out of the report", "err", tripsErr)
+		} else {
+			cov := recordingCoverage(cameraData, trips, startTimeMsInt, endTimeMsInt)
+			driving = &cov
+		}
+	}
+	var byBucket []bucket
+	if buckets != nil {
+		byBucket = bucketRecording(cameraData, startTimeMsInt, endTimeMsInt, *buckets, loc)
+	}
 	// Display the results
-	displayRecording(aggregateRecording, cameraData, startTimeMsInt, endTimeMsInt)
+	switch {
+	case *format == "json":
+		err = writeRecordingJSON(os.Stdout, deviceIDInt, aggregateRecording, driving, byBucket, cameraData, startTimeMsInt, endTimeMsInt, loc)
+	case *format == "csv" && buckets != nil:
+		err = writeBucketsCSV(os.Stdout, deviceIDInt, byBucket, cameraData, loc)
+	case *format == "csv":
+		err = writeRecordingCSV(os.Stdout, deviceIDInt, aggregateRecording, cameraData, loc)
+	default:
+		displayRecording(aggregateRecording, cameraData, startTimeMsInt, endTimeMsInt, loc)
+		if buckets != nil {
+			printBuckets(os.Stdout, byBucket, *buckets)
+		}
+		if driving != nil {
+			printCoverage(os.Stdout, *driving, loc)
+		}
+	}
+	if err != nil {
+		fail("Could not write the report", "err", err)
+	}
+	cli.LogStats(client)
+}
+
+// Runs the jobs of a --batch file and writes their results, exiting with 1 when any job failed
+func runBatchFile(flags cli.Common, path, output string, parallel int) {
+	if len(flag.Args()) != 0 {
+		fail("--batch takes the devices and windows from the file, not the command line")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fail("Could not open the batch file", "err", err)
+	}
+	jobs, err := readJobs(f)
+	f.Close()
+	if err != nil {
+		fail("Could not read the batch file", "err", err)
+	}
+	// The output is opened first, so a path that cannot be written fails before any query is spent
+	w := io.Writer(os.Stdout)
+	if output != "" {
+		out, err := os.Create(output)
+		if err != nil {
+			fail("Could not create the output file", "err", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	conf, err := flags.LoadConfig()
+	if err != nil {
+		fail("Could not load the config", "err", err)
+	}
+	client, err := flags.NewClient(conf)
+	if err != nil {
+		fail("Could not create the graphQL client", "err", err)
+	}
+	ctx, cancel := cli.SignalContext(flags.Deadline)
+	defer cancel()
+
+	slog.Info("Running batch", "jobs", len(jobs), "parallel", parallel)
+	results := runJobs(ctx, client, jobs, parallel)
+	if err := writeJobResults(w, results); err != nil {
+		fail("Could not write the results", "err", err)
+	}
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.status]++
+	}
+	cli.LogStats(client)
+	if ctx.Err() != nil {
+		slog.Warn(cli.Interrupted(ctx)+", jobs not run are marked cancelled", "cancelled", counts[jobCancelled])
+	}
+	if counts[jobError] > 0 || counts[jobCancelled] > 0 {
+		slog.Warn("Batch finished with failures", "ok", counts[jobOK], "error", counts[jobError], "cancelled", counts[jobCancelled])
+		cancel()
+		os.Exit(1)
+	}
+	slog.Info("Batch finished", "ok", counts[jobOK])
 }
 
-func displayRecording(records cameraRecordElements, data recordData, startTimeMs, endTimeMs int) {
+// Logs the error and exits, for problems that leave nothing to report
+func fail(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func displayRecording(records cameraRecordElements, data recordData, startTimeMs, endTimeMs int, loc *time.Location) {
 	fmt.Printf("\n\n")
 	for _, r := range records.cameraElement {
-		fmt.Printf("Start: %s   End: %s    Duration: %s \n", time.Unix(int64(r.startTime/1000), 0), time.Unix(int64(r.endTime/1000), 0), secToHours(r.duration/1000))
+		fmt.Printf("Start: %s   End: %s    Duration: %s \n", time.Unix(int64(r.startTime/1000), 0).In(loc), time.Unix(int64(r.endTime/1000), 0).In(loc), secToHours(r.duration/1000))
 	}
 	fmt.Println("\nVehicle Name: ", data.Device.DeviceName)
 	fmt.Println("Group Name: ", data.Device.Group.Name)
-	fmt.Printf("\n Total recording time from %s to %s is: %s\n\n", time.Unix(int64(startTimeMs/1000), 0), time.Unix(int64(endTimeMs/1000), 0), secToHours(records.totalRecord/1000))
+	fmt.Printf("\n Total recording time from %s to %s is: %s\n\n", time.Unix(int64(startTimeMs/1000), 0).In(loc), time.Unix(int64(endTimeMs/1000), 0).In(loc), secToHours(records.totalRecord/1000))
+	fmt.Println(" Time in each state:")
+	for _, state := range dashcamStates {
+		fmt.Printf("   %-26s %s\n", state.String()+":", secToHours(records.stateTotals[state]/1000))
+	}
+	for _, u := range records.unknown {
+		fmt.Printf("   %-26s %s (not counted)\n", dashcamState(u.value).String()+":", secToHours(u.duration/1000))
+	}
+	fmt.Println()
 }
 
+// Totals the time spent in each dashcam state over the window and lists the recording segments
 func parseRecording(data recordData, startTimeMs, endTimeMs int) cameraRecordElements {
-	// Possible Edge Cases
-	// Only one segment NOT POSSIBLE
-	// No segments 	NOT POSSIBLE
-	// In range, segment starts as recording
-	// In range, segment ends as recording
-	// In range, entirely one segment is recording
-	elapsedTime, endTime, startTime := 0, 0, 0
-	var cREs cameraRecordElements
-	segmentList := data.Device.ObjectStat
-
-	// Check through camera segments
-	for i := 0; i < len(segmentList)-1; i++ {
-		elapsedTime = 0
-
-		// If the segment is recording
-		if segmentList[i].IntValue == 1 {
-			// Get startTime
-			if i == 0 && segmentList[0].ChangedAtMs <= startTimeMs {
-				startTime = startTimeMs
-			} else {
-				startTime = segmentList[i].ChangedAtMs
-			}
-			// Get endTime
-			if segmentList[i+1].ChangedAtMs >= endTimeMs {
-				endTime = endTimeMs
-			} else {
-				endTime = segmentList[i+1].ChangedAtMs
+	cREs := cameraRecordElements{stateTotals: map[dashcamState]int{}}
+	unknown := map[int]int{} // Index into cREs.unknown by value
+	for _, seg := range stateSegments(data, startTimeMs, endTimeMs) {
+		elapsedTime := seg.endTime - seg.startTime
+		if !seg.state.known() {
+			i, seen := unknown[int(seg.state)]
+			if !seen {
+				i = len(cREs.unknown)
+				unknown[int(seg.state)] = i
+				cREs.unknown = append(cREs.unknown, unknownState{value: int(seg.state)})
 			}
-			// Calculate total record time, add to totalRecord time, append segment to record list
-			elapsedTime = endTime - startTime
+			cREs.unknown[i].changes++
+			cREs.unknown[i].duration += elapsedTime
+			continue
+		}
+		cREs.stateTotals[seg.state] += elapsedTime
+		if seg.state == stateRecording {
 			cREs.totalRecord += elapsedTime
-			cREs.cameraElement = append(cREs.cameraElement, cameraRecordElement{startTime, endTime, elapsedTime})
+			cREs.cameraElement = append(cREs.cameraElement, cameraRecordElement{seg.startTime, seg.endTime, elapsedTime})
 		}
 	}
 	return cREs
 }
 
-func recordingQuery(deviceID, endTimeMs, durationMs string) (recordData, error) {
-	// Read in the access token from an untracked local file
-	file, err := os.Open("config.json")
-	if err != nil {
-		fmt.Println("File open failed: ", err)
-		return recordData{}, err
+// Query for the dashcam state changes of a device over a window
+const recordingQueryDoc = `query recording($deviceId: Int64!, $endTime: Int64!, $duration: Int64!) {
+	device(id: $deviceId) {
+		group {
+			name
+		}
+		name
+		objectStat(statTypeEnum: osDDashcamState, endTime: $endTime, duration: $duration) {
+			changedAtMs
+			intValue
+		}
 	}
-	defer file.Close()
-	byteValue, err := ioutil.ReadAll(file)
+}`
+
+// initialStates looks back for the last change before a window one day at a time, newest first, and gives up
+// after maxLookbackDays. A day is what one query is safe to cover (the default chunk size), and a camera that
+// has not changed state for a month is left with an unknown starting state.
+const (
+	lookbackStepMs  = 24 * 3600 * 1000
+	maxLookbackDays = 30
+)
+
+// Fetches the recording data for a window, splitting long windows into chunks fetched concurrently.
+// The status change in effect at the start of the window, when one is found, comes first in ObjectStat.
+func recordingQuery(ctx context.Context, client *graphql.Client, deviceID, endTimeMs, durationMs int) (recordData, error) {
+	chunks, err := graphql.FetchChunks(ctx, client, endTimeMs, durationMs, func(ctx context.Context, w graphql.Window) (recordData, error) {
+		return graphql.Execute[recordData](ctx, client, recordingRequest(deviceID, w.EndMs, w.DurationMs))
+	})
+	// Any error, even with partial data, would make the recording totals wrong
 	if err != nil {
-		fmt.Println("Could not read the config file in site query", err)
-		return recordData{}, err
+		return recordData{}, fmt.Errorf("querying recording data: %w", err)
 	}
-	var conf config
-	json.Unmarshal(byteValue, &conf)
+	data := mergeRecording(chunks)
 
-	client := &http.Client{}
-	client.Timeout = time.Second * time.Duration(conf.Timeout)
+	startTimeMs := endTimeMs - durationMs
+	if stats := data.Device.ObjectStat; len(stats) > 0 && stats[0].ChangedAtMs == startTimeMs {
+		return data, nil
+	}
+	initial, found, errs := initialStates(ctx, client, []int{deviceID}, startTimeMs)
+	if errs[0] != nil {
+		return recordData{}, fmt.Errorf("querying the dashcam state before the window: %w", errs[0])
+	}
+	if found[0] {
+		data.Device.ObjectStat = append([]recordOS{initial[0]}, data.Device.ObjectStat...)
+	}
+	return data, nil
+}
 
-	query := `{
-		device(id:` + deviceID + `) {
-			group{
-				name
+// Finds the last status change at or before startTimeMs of each device, which gives the state its window
+// starts in. Devices are looked up together in batched queries, one day further back at a time, and a device
+// stops being looked for at the first day with a change.
+func initialStates(ctx context.Context, client *graphql.Client, ids []int, startTimeMs int) ([]recordOS, []bool, []error) {
+	initial := make([]recordOS, len(ids))
+	found := make([]bool, len(ids))
+	errs := make([]error, len(ids))
+	remaining := make([]int, len(ids)) // Indexes of the devices still to look back for
+	for i := range ids {
+		remaining[i] = i
+	}
+	for day := 0; day < maxLookbackDays && len(remaining) > 0; day++ {
+		items := make([]graphql.Variables, len(remaining))
+		for j, i := range remaining {
+			items[j] = graphql.Variables{"deviceId": ids[i]}
+		}
+		// The day is one window whatever the client's chunk size, a shorter one would only cost more requests
+		w := graphql.Window{EndMs: startTimeMs - day*lookbackStepMs, DurationMs: lookbackStepMs}
+		devices, batchErrs := graphql.ExecuteBatchWindows[device](ctx, client, recordingBatch, items, []graphql.Window{w})
+		var next []int
+		for j, i := range remaining {
+			// A failed device has no results to look at
+			if batchErrs[j] != nil {
+				errs[i] = batchErrs[j]
+				continue
+			}
+			if stats := devices[j][0].ObjectStat; len(stats) > 0 {
+				initial[i], found[i] = stats[len(stats)-1], true
+			} else {
+				next = append(next, i)
 			}
-			name
-		  objectStat(statTypeEnum: osDDashcamState, endTime:` + endTimeMs + `, duration: ` + durationMs + `) {
-			changedAtMs
-			intValue
-		  }
 		}
-	  }`
-
-	q := graphQL{
-		Query: query,
+		remaining = next
 	}
-	b, err := json.Marshal(q)
-	if err != nil {
-		fmt.Println("Error marshalling query information", err)
-		return recordData{}, err
+	for _, i := range remaining {
+		slog.Debug("No dashcam state change found before the window", "deviceId", ids[i], "lookbackDays", maxLookbackDays)
 	}
+	return initial, found, errs
+}
 
-	// Generate the API query
-	url := "https://api.samsara.com/v1/admin/graphql"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
-	if err != nil {
-		fmt.Printf("Error generating request: %s", err)
-		return recordData{}, err
+func recordingRequest(deviceID, endTimeMs, durationMs int) graphql.Request {
+	return graphql.Request{
+		Query: recordingQueryDoc,
+		Variables: graphql.Variables{
+			"deviceId": deviceID,
+			"endTime":  endTimeMs,
+			"duration": durationMs,
+		},
 	}
-	req.Header.Add("X-Access-Token", conf.Token)
-	// Request data
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error getting response: %s", err)
-		return recordData{}, err
-	}
-	defer resp.Body.Close()
-	// Check if we get any page errors, this is not caught by err
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return recordData{}, err
+}
+
+// Joins the recording data of consecutive chunks into what one query over the whole window returns.
+// Chunks share their boundary millisecond, so a change at a boundary comes back twice and is only kept once.
+func mergeRecording(chunks []recordData) recordData {
+	merged := chunks[0]
+	merged.Device.ObjectStat = nil
+	for _, chunk := range chunks {
+		for _, stat := range chunk.Device.ObjectStat {
+			last := len(merged.Device.ObjectStat) - 1
+			if last >= 0 && stat.ChangedAtMs <= merged.Device.ObjectStat[last].ChangedAtMs {
+				continue
+			}
+			merged.Device.ObjectStat = append(merged.Device.ObjectStat, stat)
 		}
-		var data recordData
-		json.Unmarshal(body, &data)
-		return data, nil
 	}
-	return recordData{}, errors.New("Page error:" + strconv.Itoa(resp.StatusCode))
+	return merged
 }
 
 // Formats seconds into the time on site format of Xh Ym, or Xm Ys
 func secToHours(seconds int) string {
 	if seconds/3600 >= 1 {
 		hours := seconds / 3600
-		min := seconds % 60
+		min := seconds % 3600 / 60
 		return strconv.Itoa(hours) + "h " + strconv.Itoa(min) + "m"
 	} else if seconds < 0 {
 		return "negative"
@@ -1,33 +1,48 @@
 package main
 
 import (
-	s "strconv"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
 )
 
 func TestRecordingQueryAndParseRecording(t *testing.T) {
-	// Testing the recordingQuery
-	expect1 := 9
-	endTime := "1541168971265"
-	duration := "3123000"
-	test1, err := recordingQuery("212014918236538", endTime, duration)
+	// Testing the recordingQuery, 9 changes in the window after the one in effect at its start
+	expect1 := 10
+	endTime := 1541168971265
+	duration := 3123000
+	// The mock server answers from the bundled fixtures, so no token or network is needed
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path})
+	test1, err := recordingQuery(context.Background(), client, 212014918236538, endTime, duration)
 	if err != nil {
-		t.Errorf("Page err, %d", err)
+		t.Errorf("Page err, %s", err)
 	}
 	if len(test1.Device.ObjectStat) != expect1 {
-		t.Errorf("Test Query 1 did not work, got: %v, want: %v", len(test1.Device.ObjectStat), expect1)
+		t.Fatalf("Test Query 1 did not work, got: %v, want: %v", len(test1.Device.ObjectStat), expect1)
+	}
+	if test1.Device.ObjectStat[0].IntValue != 1 || test1.Device.ObjectStat[0].ChangedAtMs >= endTime-duration {
+		t.Errorf("Did not find the recording state the window starts in, got: %+v", test1.Device.ObjectStat[0])
 	}
 	expect2 := 5 // Camera On status
-	test2 := test1.Device.ObjectStat[0].IntValue
+	test2 := test1.Device.ObjectStat[1].IntValue
 	if test2 != expect2 {
 		t.Errorf("Did not retrieve the proper camera status, got: %v, want: %v", test2, expect2)
 	}
 	// Testing the parseRecording
-	endTimeInt, _ := s.Atoi(endTime)
-	durationInt, _ := s.Atoi(duration)
-	startTimeInt := endTimeInt - durationInt
-	cameraRecordElements := parseRecording(test1, startTimeInt, endTimeInt)
-	expect3 := 2458150
+	startTime := endTime - duration
+	cameraRecordElements := parseRecording(test1, startTime, endTime)
+	// The second the window starts recording, before the first change in it, is counted
+	expect3 := 2459150
 	test3 := cameraRecordElements.totalRecord
 	// Testing the total recording time
 	if cameraRecordElements.totalRecord != expect3 {
@@ -36,6 +51,38 @@ func TestRecordingQueryAndParseRecording(t *testing.T) {
 
 }
 
+func TestRecordingQueryChunked(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	endTime, duration := 1541168971265, 3123000
+	whole, err := recordingQuery(context.Background(), graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path}), 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+
+	// One minute chunks put a boundary exactly on the change a minute into the window, which both chunks return
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+		Chunking: graphql.Chunking{Size: time.Minute, Concurrency: 8},
+	})
+	chunked, err := recordingQuery(context.Background(), client, 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// 53 chunks and the look back for the state the window starts in
+	if client.Stats().Requests != 54 {
+		t.Errorf("Window was not split, got: %d requests, want: 54", client.Stats().Requests)
+	}
+	if !reflect.DeepEqual(chunked, whole) {
+		t.Errorf("Chunked data differs from one query, got: %+v, want: %+v", chunked.Device, whole.Device)
+	}
+	startTime := endTime - duration
+	if got := parseRecording(chunked, startTime, endTime).totalRecord; got != 2459150 {
+		t.Errorf("Did not get correct total recording time, got: %v, want: %v", got, 2459150)
+	}
+}
+
 func TestSecToHours(t *testing.T) {
 	// Test if zero value decodes correctly
 	zero := 0
@@ -53,6 +100,11 @@ func TestSecToHours(t *testing.T) {
 		t.Errorf("Zero second did not work, got: %s, want: %s", time2, expect2)
 	}
 
+	// Minutes past the hour, not the seconds
+	if got := secToHours(5582); got != "1h 33m" {
+		t.Errorf("Hours and minutes did not work, got: %s, want: %s", got, "1h 33m")
+	}
+
 	// Test negative handling
 	negative := -3600
 	time3 := secToHours(negative)
@@ -62,3 +114,36 @@ func TestSecToHours(t *testing.T) {
 	}
 
 }
+
+func TestRecordingQueryVariables(t *testing.T) {
+	// The IDs and times must travel as variables, never inside the query text
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphql.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Could not decode request: %s", err)
+		}
+		if req.Query != recordingQueryDoc {
+			t.Errorf("Did not send the static query document")
+		}
+		if strings.Contains(req.Query, "212014918236538") {
+			t.Errorf("Device ID was spliced into the query text")
+		}
+		expect := map[string]interface{}{"deviceId": 212014918236538.0, "endTime": 1541168971265.0, "duration": 3123000.0}
+		for k, v := range expect {
+			if req.Variables[k] != v {
+				t.Errorf("Variable %s not sent correctly, got: %v, want: %v", k, req.Variables[k], v)
+			}
+		}
+		// A change right at the start of the window leaves nothing to look back for
+		w.Write([]byte(`{"data": {"device": {"name": "Truck 1", "objectStat": [{"changedAtMs": 1541165848265, "intValue": 1}]}}}`))
+	}))
+	defer server.Close()
+
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL})
+	if _, err := recordingQuery(context.Background(), client, 212014918236538, 1541168971265, 3123000); err != nil {
+		t.Errorf("Received an error: %s", err)
+	}
+	if client.Stats().Requests != 1 {
+		t.Errorf("Expected no look back request, got %d requests", client.Stats().Requests)
+	}
+}
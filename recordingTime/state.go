@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// dashcamState - Value of the osDDashcamState object stat
+type dashcamState int
+
+const (
+	stateRecording dashcamState = 1 // Recording
+	stateError     dashcamState = 2 // Not recording because of an error
+	stateStopped   dashcamState = 3 // Not recording, stopped
+	stateStarting  dashcamState = 4 // Camera starting up
+	stateOn        dashcamState = 5 // Camera on but not recording
+)
+
+// Every known state, in the order the breakdown is printed
+var dashcamStates = []dashcamState{stateRecording, stateError, stateStopped, stateStarting, stateOn}
+
+func (s dashcamState) String() string {
+	switch s {
+	case stateRecording:
+		return "Recording"
+	case stateError:
+		return "Not recording (error)"
+	case stateStopped:
+		return "Not recording (stopped)"
+	case stateStarting:
+		return "Camera starting"
+	case stateOn:
+		return "Camera on, not recording"
+	}
+	return fmt.Sprintf("Unknown state %d", int(s))
+}
+
+// Name of the state in machine readable output, e.g. the recordingMs column of --batch results
+func (s dashcamState) key() string {
+	switch s {
+	case stateRecording:
+		return "recording"
+	case stateError:
+		return "notRecordingError"
+	case stateStopped:
+		return "notRecordingStopped"
+	case stateStarting:
+		return "cameraStarting"
+	case stateOn:
+		return "cameraOn"
+	}
+	return fmt.Sprintf("unknown%d", int(s))
+}
+
+func (s dashcamState) known() bool {
+	return s >= stateRecording && s <= stateOn
+}
+
+// stateSegment - A stretch of the window spent in one state
+type stateSegment struct {
+	state     dashcamState
+	startTime int
+	endTime   int
+}
+
+// unknownState - A state value missing from dashcamStates, reported as a warning instead of counted
+type unknownState struct {
+	value    int
+	changes  int // Status changes to this value in the window
+	duration int // Milliseconds spent in it
+}
+
+// Splits the status changes into the segments of the window they cover. Each state lasts until the next
+// change, the last one until the end of the window or now, whichever is earlier. A change before the window
+// (see initialStates) gives the state the window starts in.
+func stateSegments(data recordData, startTimeMs, endTimeMs int) []stateSegment {
+	endTimeMs = min(endTimeMs, int(time.Now().UnixMilli()))
+	var segments []stateSegment
+	stats := data.Device.ObjectStat
+	for i, stat := range stats {
+		startTime := max(stat.ChangedAtMs, startTimeMs)
+		endTime := endTimeMs
+		if i+1 < len(stats) {
+			endTime = min(stats[i+1].ChangedAtMs, endTimeMs)
+		}
+		// Superseded before the window started, or changed at or after its end
+		if endTime <= startTime {
+			continue
+		}
+		segments = append(segments, stateSegment{dashcamState(stat.IntValue), startTime, endTime})
+	}
+	return segments
+}
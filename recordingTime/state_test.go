@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+func stats(changes ...[2]int) recordData {
+	var data recordData
+	for _, c := range changes {
+		data.Device.ObjectStat = append(data.Device.ObjectStat, recordOS{ChangedAtMs: c[0], IntValue: c[1]})
+	}
+	return data
+}
+
+func TestParseRecordingStates(t *testing.T) {
+	data := stats([2]int{1000, 4}, [2]int{2000, 1}, [2]int{5000, 2}, [2]int{6000, 9}, [2]int{6500, 3}, [2]int{7000, 5}, [2]int{7500, 9}, [2]int{8000, 1}, [2]int{9000, 5})
+	records := parseRecording(data, 1000, 10000)
+
+	// The last state lasts until the end of the window
+	want := map[dashcamState]int{stateRecording: 4000, stateError: 1000, stateStopped: 500, stateStarting: 1000, stateOn: 1500}
+	for _, state := range dashcamStates {
+		if records.stateTotals[state] != want[state] {
+			t.Errorf("%s: got %d ms, want %d ms", state, records.stateTotals[state], want[state])
+		}
+	}
+	if records.totalRecord != 4000 || len(records.cameraElement) != 2 {
+		t.Errorf("Recording segments were not kept, got: %+v", records.cameraElement)
+	}
+	// The unknown value is reported once with both of its stretches, and left out of the known states
+	if len(records.unknown) != 1 || records.unknown[0] != (unknownState{value: 9, changes: 2, duration: 1000}) {
+		t.Errorf("Unknown state was not reported, got: %+v", records.unknown)
+	}
+}
+
+func TestParseRecordingStatesFixture(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	endTime, duration := 1541168971265, 3123000
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path})
+	data, err := recordingQuery(context.Background(), client, 212014918236538, endTime, duration)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	records := parseRecording(data, endTime-duration, endTime)
+	if records.stateTotals[stateRecording] != records.totalRecord {
+		t.Errorf("Recording state total %d does not match the recording time %d", records.stateTotals[stateRecording], records.totalRecord)
+	}
+	if len(records.unknown) != 0 {
+		t.Errorf("Fixture has no unknown states, got: %+v", records.unknown)
+	}
+	// With the state the window starts in and the last state running to its end, every millisecond is covered
+	sum := 0
+	for _, total := range records.stateTotals {
+		sum += total
+	}
+	if sum != duration {
+		t.Errorf("States should cover the whole window, got: %d ms, want: %d ms", sum, duration)
+	}
+}
+
+func TestRecordingFewChanges(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	// The van without changes looks back every day there is, which the rate limit would only slow down
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+	})
+	// Truck 2 starts recording at 1541165000000 and never changes again, the Demo Van has no changes at all
+	for _, tc := range []struct {
+		name                string
+		device, start, end  int
+		recording, segments int
+	}{
+		{"no change, recording since before", 212014918137973, 1541166000000, 1541167000000, 1000000, 1},
+		{"one change", 212014918137973, 1541164000000, 1541166000000, 1000000, 1},
+		{"no change ever", 212014918000001, 1541164000000, 1541166000000, 0, 0},
+	} {
+		data, err := recordingQuery(context.Background(), client, tc.device, tc.end, tc.end-tc.start)
+		if err != nil {
+			t.Fatalf("%s: received an error: %s", tc.name, err)
+		}
+		records := parseRecording(data, tc.start, tc.end)
+		if records.totalRecord != tc.recording || len(records.cameraElement) != tc.segments {
+			t.Errorf("%s: got %d ms in %d segments, want %d ms in %d", tc.name, records.totalRecord, len(records.cameraElement), tc.recording, tc.segments)
+		}
+	}
+}
+
+func TestInitialStateLookback(t *testing.T) {
+	// Every query's window is recorded, so the look back can be checked against the chunk size
+	fixtures, err := mock.DefaultFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mockServer := mock.NewServer(fixtures, mock.Options{})
+	var mu sync.Mutex
+	longest := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req graphql.Request
+		json.Unmarshal(body, &req)
+		if d, ok := req.Variables["duration"].(float64); ok {
+			mu.Lock()
+			longest = max(longest, int(d))
+			mu.Unlock()
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		mockServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	day := 24 * 3600 * 1000
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+		Chunking: graphql.Chunking{Size: 24 * time.Hour, Concurrency: 4},
+	})
+
+	// Truck 2's only change is 10 days before the window, and 40 days is past the look back
+	for _, tc := range []struct {
+		daysAfter int
+		found     bool
+	}{{10, true}, {40, false}} {
+		start := 1541165000000 + tc.daysAfter*day
+		initial, found, errs := initialStates(context.Background(), client, []int{212014918137973}, start)
+		if errs[0] != nil {
+			t.Fatalf("%d days: received an error: %s", tc.daysAfter, errs[0])
+		}
+		if found[0] != tc.found || (tc.found && initial[0] != (recordOS{ChangedAtMs: 1541165000000, IntValue: 1})) {
+			t.Errorf("%d days: got %+v (found %v), want found %v", tc.daysAfter, initial[0], found[0], tc.found)
+		}
+	}
+	if longest > day {
+		t.Errorf("Look back was not chunked, longest query: %d ms", longest)
+	}
+}
+
+func TestStateSegmentsFutureEnd(t *testing.T) {
+	// A window ending in the future only counts the last state up to now
+	now := int(time.Now().UnixMilli())
+	segments := stateSegments(stats([2]int{now - 60000, 1}), now-120000, now+3600000)
+	if len(segments) != 1 || segments[0].startTime != now-60000 {
+		t.Fatalf("Expected one segment from the change, got: %+v", segments)
+	}
+	if end := segments[0].endTime; end < now || end > now+60000 {
+		t.Errorf("Expected the last state to end now, got %d (now %d)", end, now)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+)
+
+// ANSI codes to move the cursor home and clear the terminal, so each poll redraws the status in place
+const clearScreen = "\033[H\033[2J"
+
+// Parses the endTimeMs argument, where 0 or "now" mean the current time
+func parseEndTime(arg string, now time.Time) (int, bool, error) {
+	if arg == "0" || strings.EqualFold(arg, "now") {
+		return int(now.UnixMilli()), true, nil
+	}
+	endTimeMs, err := strconv.Atoi(arg)
+	return endTimeMs, false, err
+}
+
+// Reports whether f is a terminal, where the watch status can be redrawn in place
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Polls the device every interval from startTimeMs up to now and writes the live status to out until ctx is
+// cancelled. A terminal is redrawn in place, anything else (e.g. a pipe or file) gets one block per poll.
+// A failed poll is logged and tried again at the next tick.
+func watchRecording(ctx context.Context, client *graphql.Client, deviceID, startTimeMs int, interval time.Duration, out io.Writer, inPlace bool, loc *time.Location) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		endTimeMs := int(time.Now().UnixMilli())
+		data, err := recordingQuery(ctx, client, deviceID, endTimeMs, endTimeMs-startTimeMs)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			slog.Warn("Could not poll the recording data, trying again", "err", err, "interval", interval)
+		default:
+			if inPlace {
+				fmt.Fprint(out, clearScreen)
+			}
+			fmt.Fprintln(out, liveStatus(data, startTimeMs, endTimeMs, loc))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// The current state and how long it has lasted, and the total recording time so far
+func liveStatus(data recordData, startTimeMs, endTimeMs int, loc *time.Location) string {
+	var b strings.Builder
+	at := func(ms int) string {
+		return time.UnixMilli(int64(ms)).In(loc).Format(time.DateTime)
+	}
+	fmt.Fprintf(&b, "Vehicle: %s (%s), updated %s\n", data.Device.DeviceName, data.Device.Group.Name, at(endTimeMs))
+	segments := stateSegments(data, startTimeMs, endTimeMs)
+	if len(segments) == 0 {
+		fmt.Fprintf(&b, "Current state:   unknown, no status change found\n")
+	} else {
+		current := segments[len(segments)-1]
+		fmt.Fprintf(&b, "Current state:   %s since %s (%s)\n", current.state, at(current.startTime), secToHours((current.endTime-current.startTime)/1000))
+	}
+	records := parseRecording(data, startTimeMs, endTimeMs)
+	fmt.Fprintf(&b, "Total recording: %s since %s", secToHours(records.totalRecord/1000), at(startTimeMs))
+	return b.String()
+}
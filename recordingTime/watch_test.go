@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
+)
+
+func TestParseEndTime(t *testing.T) {
+	now := time.UnixMilli(1541168971265)
+	for _, arg := range []string{"0", "now", "NOW"} {
+		if end, live, err := parseEndTime(arg, now); err != nil || !live || end != 1541168971265 {
+			t.Errorf("%q: got %d %v %v, want the current time", arg, end, live, err)
+		}
+	}
+	if end, live, err := parseEndTime("1540400526230", now); err != nil || live || end != 1540400526230 {
+		t.Errorf("Expected a fixed end time, got %d %v %v", end, live, err)
+	}
+	if _, _, err := parseEndTime("yesterday", now); err == nil {
+		t.Errorf("Expected an error for an invalid end time")
+	}
+}
+
+func TestLiveStatus(t *testing.T) {
+	data := stats([2]int{1000, 1}, [2]int{61000, 3}, [2]int{70000, 1})
+	data.Device.DeviceName = "Truck 1"
+	status := liveStatus(data, 0, 190000, time.UTC)
+	for _, want := range []string{"Vehicle: Truck 1", "Current state:   Recording since 1970-01-01 00:01:10 (2m 0s)", "Total recording: 3m 0s"} {
+		if !strings.Contains(status, want) {
+			t.Errorf("Status is missing %q:\n%s", want, status)
+		}
+	}
+	if status := liveStatus(recordData{}, 0, 190000, time.UTC); !strings.Contains(status, "unknown") {
+		t.Errorf("Expected an unknown state without changes, got:\n%s", status)
+	}
+}
+
+func TestWatchRecording(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	// Truck 2 started recording in 2018 and has not changed since, so it is still recording now
+	var out strings.Builder
+	watchRecording(ctx, client, 212014918137973, 1541164000000, 20*time.Millisecond, &out, true, time.UTC)
+	polls := strings.Count(out.String(), clearScreen)
+	if polls < 2 {
+		t.Fatalf("Expected the status to be redrawn on every poll, got %d polls:\n%s", polls, out.String())
+	}
+	last := out.String()[strings.LastIndex(out.String(), clearScreen):]
+	if !strings.Contains(last, "Current state:   Recording since 2018-11-02 13:23:20") {
+		t.Errorf("Expected Truck 2 to still be recording, got:\n%s", last)
+	}
+}
@@ -1,35 +1,26 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"log/slog"
 	"math"
-	"net/http"
 	"os"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/thewhofan23/OwlCode/cli"
+	"github.com/thewhofan23/OwlCode/graphql"
 )
 
 // **** HTTP Structs *****
 
-// Query - Used to marshal graphQL query
-type graphQL struct {
-	Query     string
-	Variables struct{}
-}
-
-// Config - Importing configs from config.json
-type config struct {
-	Token      string  // Access token
-	Timeout    int     // HTTP timeout
-	BoundMulti float32 // Bound Multiplier
-}
-
 // **** Device Data Structs *****
 
 // Address - Create struct to unmarshal and hold the address
@@ -64,19 +55,33 @@ type vehicleActivityReport struct {
 
 // Devices - Create struct to unmarshal and hold each device
 type devices struct {
+	ID   int `json:"id"`
 	Name string
 	VAR  vehicleActivityReport `json:"vehicleActivityReport"`
 }
 
-// Group - Create struct to unmarshal and hold Devices
+// Group - Create struct to unmarshal and hold the vehicles of a group
 type group struct {
 	Devices []devices
 }
 
-type tosData struct {
+type groupData struct {
 	Group group
 }
 
+// FetchResult - Create struct to hold how many vehicles were fetched and which could not be
+type fetchResult struct {
+	vehicles int
+	failed   []failedDevice
+}
+
+// FailedDevice - Create struct to hold a vehicle left out of the report and why
+type failedDevice struct {
+	id   int
+	name string
+	err  error
+}
+
 // **** Site/Address Structs *****
 
 // Site - Create struct to unmarshal and hold Site data
@@ -127,242 +132,396 @@ func main() {
 
 	programStart := time.Now()
 
-	fmt.Println("\nWelcome to the Time on Site Report Tool!")
+	// Grab CLI flags and arguments
+	var flags cli.Common
+	flags.Register(flag.CommandLine)
+	perDevice := flag.Bool("per-device", false, "List the group's vehicles, then fetch each one with its own query so one broken vehicle cannot fail the report")
+	workers := flag.Int("device-workers", 8, "Vehicles fetched at once with --per-device, or when a window too long for one query is fetched per device")
+	flag.Parse()
+	input := flag.Args()
+	if err := flags.SetupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// "cache clear" manages the response cache instead of running the report
+	if len(input) > 0 && input[0] == "cache" {
+		if err := flags.CacheCommand(input[1:]); err != nil {
+			fail("Cache command failed", "err", err)
+		}
+		return
+	}
 
-	// Grab CLI arguments
-	input := os.Args
+	// "doctor" checks the config and the connection to the API instead of running the report
+	if len(input) > 0 && input[0] == "doctor" {
+		ctx, cancel := cli.SignalContext(flags.Deadline)
+		defer cancel()
+		if err := flags.Doctor(ctx, os.Stdout); err != nil {
+			fail("Doctor check failed", "err", err)
+		}
+		return
+	}
 
 	// Check if CLI argument length is valid
-	if len(input) != 5 {
-		fmt.Println("Format Invalid!: Please follow this format: ./timeOnSite <groupID> <endTimeMs> <durationMs> <itemize trips (bool)>")
-		return
+	if len(input) != 4 {
+		fmt.Fprintln(os.Stderr, "Format Invalid!: Please follow this format: ./timeOnSite "+cli.Usage+" [--per-device [--device-workers 8]] <groupID> <endTimeMs> <durationMs> <itemize trips (bool)>")
+		os.Exit(2)
 	}
+	slog.Debug("Welcome to the Time on Site Report Tool!")
 
-	groupID := input[1]    // e.g 3991
-	endTimeMs := input[2]  // e.g 1540341729936
-	durationMs := input[3] // e.g 3600000
+	groupID := input[0]    // e.g 3991
+	endTimeMs := input[1]  // e.g 1540341729936
+	durationMs := input[2] // e.g 3600000
 	expanded := true       // Assume they want expanded view
 
 	// Input checking the expanded view option. If false or f, do not expand, otherwise
-	if strings.ToLower(input[4]) == "false" || strings.ToLower(input[4]) == "f" {
+	if strings.ToLower(input[3]) == "false" || strings.ToLower(input[3]) == "f" {
 		expanded = false // false
-	} else if strings.ToLower(input[4]) == "true" || strings.ToLower(input[4]) == "t" {
-		fmt.Println("Registered true, Itemizing the trips\n")
+	} else if strings.ToLower(input[3]) == "true" || strings.ToLower(input[3]) == "t" {
+		slog.Debug("Registered true, Itemizing the trips")
 	} else {
-		fmt.Println("Could not understand expanded argument,", input[4], ". Please input as \"true\" or \"false\" for expanded view. Assuming true. \n")
+		slog.Warn("Could not understand expanded argument, please input as \"true\" or \"false\" for expanded view. Assuming true.", "expanded", input[3])
 	}
 
 	// Check the input arguments to see if valid integers
-	_, err := strconv.Atoi(groupID)
+	intGroupID, err := strconv.Atoi(groupID)
 	if err != nil {
-		fmt.Println("Could not convert groupID to an integer")
-		return
+		fail("Could not convert groupID to an integer", "err", err)
 	}
 	intEndTime, err := strconv.Atoi(endTimeMs)
 	if err != nil {
-		fmt.Println("Could not convert endTime to an integer")
-		return
+		fail("Could not convert endTime to an integer", "err", err)
 	}
 	intDuration, err := strconv.Atoi(durationMs)
 	if err != nil {
-		fmt.Println("Could not convert duration to an integer")
-		return
+		fail("Could not convert duration to an integer", "err", err)
+	}
+
+	conf, err := flags.LoadConfig()
+	if err != nil {
+		fail("Could not load the config", "err", err)
+	}
+	loc, err := conf.Location()
+	if err != nil {
+		fail("Could not load the timezone", "err", err)
+	}
+	client, err := flags.NewClient(conf)
+	if err != nil {
+		fail("Could not create the graphQL client", "err", err)
 	}
 
-	fmt.Println("Running Time on Site Report...")
+	// Ctrl-C or the deadline cancels the queries in flight and stops the site workers
+	ctx, cancel := cli.SignalContext(flags.Deadline)
+	defer cancel()
+
+	slog.Info("Running Time on Site Report...", "group", intGroupID)
 	start := time.Now()
 
-	// Grab vehicle and driver data from graphQL
-	tosData, err := tosQuery(groupID, endTimeMs, durationMs)
-	if err != nil {
-		fmt.Println(err)
+	// Grab site data from graphQL first, it is small and every vehicle is matched against it
+	siteData, err := siteQuery(ctx, client, intGroupID)
+	if ctx.Err() != nil {
+		slog.Warn(cli.Interrupted(ctx) + " while fetching site data, nothing to report.")
 		return
 	}
-	fmt.Println("Total time to fetch vehicle/location data: ", time.Since(start))
+	if err != nil {
+		fail("Could not fetch the site data", "err", err)
+	}
+	slog.Info("Fetched site data", "sites", len(siteData.Group.Sites), "duration", time.Since(start))
 	start1 := time.Now()
 
-	// Grab site data from graphQL
-	siteData, err := siteQuery(groupID)
-	if err != nil {
-		fmt.Println(err)
-		return
+	// Stream vehicle and driver data from graphQL, matching each vehicle against the sites as it arrives
+	report, fetched, err := runReport(ctx, client, siteData, intGroupID, intEndTime, intDuration, max(*workers, 1), *perDevice, conf.BoundMulti)
+	if ctx.Err() == nil && err != nil {
+		fail("Could not fetch the vehicle data", "err", err)
 	}
+	slog.Info("Fetched and matched vehicle/location data", "vehicles", fetched.vehicles, "failed", len(fetched.failed), "duration", time.Since(start1))
 
-	fmt.Println("Total time to fetch site data: ", time.Since(start1))
+	// Format and print the results of checkSite, noting when they were cut short
+	printSite(report, expanded, loc)
+	printFailed(fetched.failed)
+	if ctx.Err() != nil {
+		slog.Warn(cli.Interrupted(ctx)+", the report above is partial.", "vehicles", fetched.vehicles)
+	}
+	cli.LogStats(client)
+	slog.Info("Total Program Runtime", "duration", time.Since(programStart))
+}
 
-	// Run the time on site report using the data from earlier graphQL queries
-	report := checkSite(siteData, tosData, intEndTime, intDuration)
-	// Format and print the results of checkSite
-	printSite(report, expanded)
-	fmt.Println("Total Program Runtime: ", time.Since(programStart))
+// Logs the error and exits, for problems that leave nothing to report
+func fail(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 // **** SUPPORTING FUNCTIONS ****
 
-// Requests driver and vehicle information from graphQL
-// Nearly all runtime of program happens here when requesting data from the server.
-func tosQuery(id, end, duration string) (tosData, error) {
-	// Read in the access token from an untracked local file
-	file, err := os.Open("config.json")
-	if err != nil {
-		fmt.Println("File open failed: ", err)
-		return tosData{}, err
+// The trip fields the report uses, shared by the group and per-device queries
+const tripEntryFields = `tripEntries {
+	start {
+		time
+		lat
+		lng
+		address {
+			name
+		}
 	}
-	defer file.Close()
-	byteValue, err := ioutil.ReadAll(file)
-	if err != nil {
-		fmt.Println("Could not read the config file in site query", err)
-		return tosData{}, err
-	}
-	var conf config
-	json.Unmarshal(byteValue, &conf)
-
-	client := &http.Client{}
-	client.Timeout = time.Second * time.Duration(conf.Timeout)
-
-	query := `
-		{
-			group(id: ` + id + `) {
-				devices {
-				name
-				vehicleActivityReport(endTime:` + end + `, duration:` + duration + `) {
-					tripEntries {
-					start {
-						time
-						lat
-						lng
-						address {
-						name
-						}
-					}
-					end {
-						time
-						lat
-						lng
-						address {
-						name
-						}
-					}
-					driver {
-						name
-					}
-					}
-				}
-				}
-			}
-			}
-			
-		`
-	q := graphQL{
-		Query: query,
+	end {
+		time
+		lat
+		lng
+		address {
+			name
+		}
 	}
-	b, err := json.Marshal(q)
-	if err != nil {
-		fmt.Println("Error marshalling query information", err)
-		return tosData{}, err
+	driver {
+		name
 	}
+}`
 
-	// Generate the API query
-	url := "https://api.samsara.com/v1/admin/graphql"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
-	if err != nil {
-		fmt.Printf("Error generating request: %s", err)
-		return tosData{}, err
-	}
-	req.Header.Add("X-Access-Token", conf.Token)
-	// Request data
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error getting response: %s", err)
-		return tosData{}, err
+// Query for every trip of every vehicle in a group over a window
+const tosQueryDoc = `query timeOnSite($groupId: Int64!, $endTime: Int64!, $duration: Int64!) {
+	group(id: $groupId) {
+		devices {
+			id
+			name
+			vehicleActivityReport(endTime: $endTime, duration: $duration) {
+				` + tripEntryFields + `
+			}
+		}
 	}
-	defer resp.Body.Close()
-	// Check if we get any page errors, this is not caught by err
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return tosData{}, err
+}`
+
+// Query for the vehicles of a group without their trips, for fetching each vehicle on its own
+const deviceListQueryDoc = `query groupDevices($groupId: Int64!) {
+	group(id: $groupId) {
+		devices {
+			id
+			name
 		}
-		var data tosData
-		json.Unmarshal(body, &data)
-		return data, nil
 	}
-	return tosData{}, errors.New("Page error:" + strconv.Itoa(resp.StatusCode))
+}`
 
+// The trips of one vehicle over a window, batched under aliases with other vehicles' trips
+var deviceTripsBatch = graphql.Batch{
+	Name: "deviceTrips",
+	Field: `device(id: $deviceId) {
+		id
+		name
+		vehicleActivityReport(endTime: $endTime, duration: $duration) {
+			` + tripEntryFields + `
+		}
+	}`,
+	Types: map[string]string{"deviceId": "Int64!", "endTime": "Int64!", "duration": "Int64!"},
 }
 
-// Requests address information from graphQL
-func siteQuery(id string) (siteData, error) {
-	// Read in the access token from an untracked local file
-	file, err := os.Open("config.json")
-	if err != nil {
-		fmt.Println("File open failed: ", err)
-		return siteData{}, err
-	}
-	defer file.Close()
-	byteValue, err := ioutil.ReadAll(file)
-	if err != nil {
-		fmt.Println("Could not read the config file in site query", err)
-		return siteData{}, err
-	}
-	var conf config
-	json.Unmarshal(byteValue, &conf)
-
-	client := &http.Client{}
-	client.Timeout = time.Second * time.Duration(conf.Timeout)
-
-	query := `
-	{
-		group(id:` + id + `) {
-			addresses {
-				name
-				latitude
-				longitude
-				radius
-			}
+// Query for the addresses (sites) of a group
+const siteQueryDoc = `query sites($groupId: Int64!) {
+	group(id: $groupId) {
+		addresses {
+			name
+			latitude
+			longitude
+			radius
 		}
 	}
-	`
+}`
 
-	q := graphQL{
-		Query: query,
+// Streams driver and vehicle information for the whole group from graphQL into vehicles, closing it when done.
+// Nearly all runtime of program happens here when requesting data from the server.
+// The window is always fetched with one query: a vehicle's trips can only be joined across chunks once every
+// chunk is in, which would hold the whole group in memory. runReport fetches long windows with deviceQuery
+// instead, where only one batch of vehicles is held at a time.
+func tosQuery(ctx context.Context, client *graphql.Client, id, end, duration int, vehicles chan<- devices) (fetchResult, error) {
+	defer close(vehicles)
+	var result fetchResult
+	err := streamVehicles(ctx, client, id, graphql.Window{EndMs: end, DurationMs: duration}, func(vehicle devices) error {
+		select {
+		case vehicles <- vehicle:
+			result.vehicles++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	// Some vehicles failing still leaves the rest of the report usable, so only warn about them
+	if graphql.IsPartial(err) {
+		slog.Warn("Some vehicle data could not be fetched, report may be incomplete", "err", err)
+		return result, nil
 	}
-	b, err := json.Marshal(q)
 	if err != nil {
-		fmt.Println("Error marshalling query information", err)
-		return siteData{}, err
+		return result, fmt.Errorf("querying vehicle data: %w", err)
 	}
+	return result, nil
+}
 
-	// Generate the API query
-	url := "https://api.samsara.com/v1/admin/graphql"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
-	if err != nil {
-		fmt.Printf("Error generating request: %s", err)
-		return siteData{}, err
+// Streams the vehicles of a group with their trips over one window, calling fn for each as it arrives
+func streamVehicles(ctx context.Context, client *graphql.Client, id int, w graphql.Window, fn func(devices) error) error {
+	req := graphql.Request{
+		Query: tosQueryDoc,
+		Variables: graphql.Variables{
+			"groupId":  id,
+			"endTime":  w.EndMs,
+			"duration": w.DurationMs,
+		},
+	}
+	return graphql.Stream(ctx, client, req, []string{"group", "devices"}, fn)
+}
+
+func (t tripEntry) span() (int, int) {
+	return t.Start.Time, t.End.Time
+}
+
+// A trip the API cut at a chunk boundary, put back together with its rest in next
+func (t tripEntry) joined(next tripEntry) tripEntry {
+	t.End = next.End
+	return t
+}
+
+// Fetches the trips of the group's vehicles with per-vehicle queries, packed into batched requests of
+// which up to workers run at a time, and hands them to vehicles in the order the group lists them,
+// closing it when done. A vehicle that cannot be fetched is left out and listed in the result instead
+// of failing the report.
+func deviceQuery(ctx context.Context, client *graphql.Client, id, end, duration, workers int, vehicles chan<- devices) (fetchResult, error) {
+	defer close(vehicles)
+	var result fetchResult
+	list, err := graphql.Execute[groupData](ctx, client, graphql.Request{
+		Query:     deviceListQueryDoc,
+		Variables: graphql.Variables{"groupId": id},
+	})
+	if graphql.IsPartial(err) {
+		slog.Warn("Some vehicles could not be listed, report may be incomplete", "err", err)
+	} else if err != nil {
+		return result, fmt.Errorf("listing the group's vehicles: %w", err)
+	}
+	listed := list.Group.Devices
+
+	// Each vehicle gets its own result channel, so finished vehicles wait for the ones listed before them
+	type fetched struct {
+		vehicle devices
+		err     error
+	}
+	results := make([]chan fetched, len(listed))
+	for i := range results {
+		results[i] = make(chan fetched, 1)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	size := client.Batching.Size
+	if size <= 0 {
+		size = graphql.DefaultBatching.Size
+	}
+	slots := make(chan struct{}, max(workers, 1))
+	go func() {
+		for start := 0; start < len(listed); start += size {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			batch := listed[start:min(start+size, len(listed))]
+			go func() {
+				defer func() { <-slots }()
+				trips, errs := vehicleTrips(ctx, client, batch, end, duration)
+				for i := range batch {
+					results[start+i] <- fetched{trips[i], errs[i]}
+				}
+			}()
+		}
+	}()
+
+	for i, vehicle := range listed {
+		var r fetched
+		select {
+		case r = <-results[i]:
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+		if graphql.IsPartial(r.err) {
+			slog.Warn("Some trips could not be fetched, report may be incomplete", "vehicle", vehicle.Name, "err", r.err)
+		} else if r.err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			slog.Warn("Could not fetch vehicle, leaving it out of the report", "vehicle", vehicle.Name, "err", r.err)
+			result.failed = append(result.failed, failedDevice{vehicle.ID, vehicle.Name, r.err})
+			continue
+		}
+		select {
+		case vehicles <- r.vehicle:
+			result.vehicles++
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
 	}
-	req.Header.Add("X-Access-Token", conf.Token)
-	// Request data from server
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Error getting response: %s", err)
-		return siteData{}, err
+	return result, nil
+}
+
+// Fetches the trips of several vehicles with batched queries, in chunks when the window is long, joining each
+// vehicle's chunks with graphql.JoinChunks. Returns the trips and error of each vehicle, in the order given.
+func vehicleTrips(ctx context.Context, client *graphql.Client, listed []devices, end, duration int) ([]devices, []error) {
+	items := make([]graphql.Variables, len(listed))
+	for i, vehicle := range listed {
+		items[i] = graphql.Variables{"deviceId": vehicle.ID}
+	}
+	windows := client.Chunking.Split(end, duration)
+	chunks, errs := graphql.ExecuteBatchWindows[devices](ctx, client, deviceTripsBatch, items, windows)
+
+	// Partial data is still merged, any chunk failing outright fails the vehicle
+	trips := make([]devices, len(listed))
+	for i := range listed {
+		if errs[i] != nil && !graphql.IsPartial(errs[i]) {
+			continue
+		}
+		tripChunks := make([][]tripEntry, len(chunks[i]))
+		for c, vehicle := range chunks[i] {
+			tripChunks[c] = vehicle.VAR.TripEntries
+		}
+		trips[i] = devices{ID: listed[i].ID, Name: listed[i].Name}
+		trips[i].VAR.TripEntries = graphql.JoinChunks(windows, tripChunks, tripEntry.span, tripEntry.joined)
 	}
-	defer resp.Body.Close()
-	// Check if we get any page errors, this is not caught by err
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return siteData{}, err
+	return trips, errs
+}
+
+// Runs the report for a group, checking the vehicles against the sites while they are still downloading.
+// With perDevice, or a window the client splits into chunks, each vehicle is fetched with its own query in
+// batches of which workers run at a time, otherwise the whole group comes back in one.
+func runReport(ctx context.Context, client *graphql.Client, sd siteData, id, end, duration, workers int, perDevice bool, boundMulti float32) ([]siteOverall, fetchResult, error) {
+	if chunks := len(client.Chunking.Split(end, duration)); !perDevice && chunks > 1 {
+		slog.Info("Window is too long for one group query, fetching the vehicles per device", "chunks", chunks)
+		perDevice = true
+	}
+	// Buffered so the download runs a little ahead of the site checks
+	vehicles := make(chan devices, 16)
+	var result fetchResult
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if perDevice {
+			result, err = deviceQuery(ctx, client, id, end, duration, workers, vehicles)
+		} else {
+			result, err = tosQuery(ctx, client, id, end, duration, vehicles)
 		}
-		var data siteData
-		json.Unmarshal(body, &data)
-		return data, nil
+	}()
+	report := checkSite(ctx, sd, vehicles, end, duration, boundMulti)
+	<-done
+	return report, result, err
+}
+
+// Requests address information from graphQL
+func siteQuery(ctx context.Context, client *graphql.Client, id int) (siteData, error) {
+	req := graphql.Request{
+		Query:     siteQueryDoc,
+		Variables: graphql.Variables{"groupId": id},
+	}
+	data, err := graphql.Execute[siteData](ctx, client, req)
+	if err != nil {
+		return siteData{}, fmt.Errorf("querying site data: %w", err)
 	}
-	return siteData{}, errors.New("Page error:" + strconv.Itoa(resp.StatusCode))
+	return data, nil
 }
 
 // Creates the bounding rectangle used to quickly condition if GPS coordinate is within a site
-func getGPSBound(lat, long, r float32) (latLongRange, error) {
+func getGPSBound(lat, long, r, multi float32) (latLongRange, error) {
 	// If no radius, just return initial coordinates
 	if r == 0 {
 		return latLongRange{long, long, lat, lat}, nil
@@ -372,24 +531,9 @@ func getGPSBound(lat, long, r float32) (latLongRange, error) {
 		r = -r
 	}
 
-	file, err := os.Open("config.json")
-	if err != nil {
-		fmt.Println("File open failed: ", err)
-		return latLongRange{}, err
-	}
-	defer file.Close()
-	byteValue, err := ioutil.ReadAll(file)
-	if err != nil {
-		fmt.Println("Could not read the json config file in!", err)
-		return latLongRange{}, err
-	}
-	var conf config
-	json.Unmarshal(byteValue, &conf)
-
 	// Assuming radius is sufficently small, and vehicles are not driving north or south enough,
 	// such that we have to check for latitude overlapping at the poles
 	var bound latLongRange
-	multi := conf.BoundMulti
 	bound.latMin = lat - (multi*r/6371000)*180/math.Pi
 	bound.latMax = lat + (multi*r/6371000)*180/math.Pi
 	// // Check if latitude bounds overlap over north or south poles, used to catch very large bounds
@@ -408,88 +552,134 @@ func getGPSBound(lat, long, r float32) (latLongRange, error) {
 	return bound, nil
 }
 
-func siteVehicle(wg *sync.WaitGroup, siteReport *siteOverall, s site, td tosData, startTime, endTime, duration int) {
-	defer wg.Done()
-	lineEntry := make([]siteReportLine, 0)
-	bound, err := getGPSBound(s.Latitude, s.Longitude, s.Radius)
-	if err != nil {
-		fmt.Println("Could not define the GPS bounds for "+s.Name, err)
-		return
-	}
-	var siteReportElem siteOverall
+// Finds the visits of one vehicle to a site, returning them with the total seconds spent there
+func siteVisits(s site, bound latLongRange, vehicle devices, startTime, endTime int) ([]siteReportLine, int) {
+	var lineEntry []siteReportLine
 	totalTimeAtSite := 0
-	totalUniqVehicles := 0
-	totalUniqVisits := 0
-	// For each site, check each vehicle
-	for _, vehicle := range td.Group.Devices {
-		didVisit := false
-		// Check each end of trip for each vehicle for each site to figure out if vehicle ended within a site
-		for i, trip := range vehicle.VAR.TripEntries {
-
-			if i == 0 && greatCircleDist(trip.Start.Lat, trip.Start.Lng, s.Latitude, s.Longitude) <= s.Radius {
-				arrivalTime := startTime
-				departureTime := trip.Start.Time
-				if departureTime >= arrivalTime {
-					sRL := siteReportLine{trip.Driver.Name, arrivalTime, departureTime, vehicle.Name, trip.Start.Lat, trip.Start.Lng}
-					lineEntry = append(lineEntry, sRL)
-					totalTimeAtSite += (departureTime - arrivalTime) / 1000
-					totalUniqVisits++
-					didVisit = true
-				}
+	// Check each end of trip for each vehicle for each site to figure out if vehicle ended within a site
+	for i, trip := range vehicle.VAR.TripEntries {
+
+		if i == 0 && greatCircleDist(trip.Start.Lat, trip.Start.Lng, s.Latitude, s.Longitude) <= s.Radius {
+			arrivalTime := startTime
+			departureTime := trip.Start.Time
+			if departureTime >= arrivalTime {
+				sRL := siteReportLine{trip.Driver.Name, arrivalTime, departureTime, vehicle.Name, trip.Start.Lat, trip.Start.Lng}
+				lineEntry = append(lineEntry, sRL)
+				totalTimeAtSite += (departureTime - arrivalTime) / 1000
 			}
+		}
 
-			// Check if this is the end of the recorded trips, if so, use user inputted endTime as the departureTime
-			var departureTime int
-			if i >= len(vehicle.VAR.TripEntries)-1 {
-				departureTime = endTime
-			} else {
-				departureTime = vehicle.VAR.TripEntries[i+1].Start.Time
-			}
-			// Check if point is within bounds and within time frame before using greatCircleDist (heavy computation)
-			if trip.End.Lat >= bound.latMin && trip.End.Lat <= bound.latMax &&
-				trip.End.Lng >= bound.longMin && trip.End.Lng <= bound.longMax && departureTime-trip.End.Time > 0 && trip.End.Time >= startTime {
-
-				// Calculate if distance is within radius using great circle formula
-				if greatCircleDist(trip.End.Lat, trip.End.Lng, s.Latitude, s.Longitude) <= s.Radius {
-					arrivalTime := trip.End.Time
-					sRL := siteReportLine{trip.Driver.Name, arrivalTime, departureTime, vehicle.Name, trip.End.Lat, trip.End.Lng}
-					lineEntry = append(lineEntry, sRL)
-					totalTimeAtSite += (departureTime - arrivalTime) / 1000
-					totalUniqVisits++
-					didVisit = true
-				}
+		// Check if this is the end of the recorded trips, if so, use user inputted endTime as the departureTime
+		var departureTime int
+		if i >= len(vehicle.VAR.TripEntries)-1 {
+			departureTime = endTime
+		} else {
+			departureTime = vehicle.VAR.TripEntries[i+1].Start.Time
+		}
+		// Check if point is within bounds and within time frame before using greatCircleDist (heavy computation)
+		if trip.End.Lat >= bound.latMin && trip.End.Lat <= bound.latMax &&
+			trip.End.Lng >= bound.longMin && trip.End.Lng <= bound.longMax && departureTime-trip.End.Time > 0 && trip.End.Time >= startTime {
+
+			// Calculate if distance is within radius using great circle formula
+			if greatCircleDist(trip.End.Lat, trip.End.Lng, s.Latitude, s.Longitude) <= s.Radius {
+				arrivalTime := trip.End.Time
+				sRL := siteReportLine{trip.Driver.Name, arrivalTime, departureTime, vehicle.Name, trip.End.Lat, trip.End.Lng}
+				lineEntry = append(lineEntry, sRL)
+				totalTimeAtSite += (departureTime - arrivalTime) / 1000
 			}
 		}
-		// If the vehicle visited one site during time range, increment number of vehicles that visited by one
-		if didVisit {
-			totalUniqVehicles++
+	}
+	return lineEntry, totalTimeAtSite
+}
+
+// VehicleVisits - Create struct to hold the visits of one vehicle to one site
+type vehicleVisits struct {
+	seq       int // Order the vehicle arrived in, keeps the report in the order of the API response
+	lineEntry []siteReportLine
+	time      int
+}
+
+// Checks the vehicles received on vehicles against every site as they arrive, building the report for each site.
+// Vehicles are spread over a worker per CPU and only their visits are kept, so memory does not grow with the download.
+// If ctx is cancelled the workers stop early and the report only covers the vehicles checked so far.
+func checkSite(ctx context.Context, sd siteData, vehicles <-chan devices, endTime int, duration int, boundMulti float32) []siteOverall {
+	startTime := endTime - duration
+	// Bounds are worked out once per site, sites without valid bounds are left out of the report
+	bounds := make([]latLongRange, len(sd.Group.Sites))
+	valid := make([]bool, len(sd.Group.Sites))
+	for i, s := range sd.Group.Sites {
+		bound, err := getGPSBound(s.Latitude, s.Longitude, s.Radius, boundMulti)
+		if err != nil {
+			slog.Warn("Could not define the GPS bounds, skipping site", "site", s.Name, "err", err)
+			continue
 		}
+		bounds[i], valid[i] = bound, true
 	}
-	// Append this site's information to the total site list, if one vehicle has visited
-	if totalUniqVehicles > 0 {
-		siteReportElem = siteOverall{lineEntry, s.Name, totalUniqVehicles, totalUniqVisits, totalTimeAtSite}
-		*siteReport = siteReportElem
+
+	var mu sync.Mutex // Guards visits
+	visits := make([][]vehicleVisits, len(sd.Group.Sites))
+	// Receives the next vehicle with its position in the stream. The receive and the count share their own lock,
+	// so a worker waiting on the download never holds up the others recording visits.
+	var recv sync.Mutex
+	seq := 0
+	next := func() (devices, int, bool) {
+		recv.Lock()
+		defer recv.Unlock()
+		vehicle, ok := <-vehicles
+		seq++
+		return vehicle, seq, ok
 	}
-}
 
-// Iterates through site, vehicle, and driver information to
-func checkSite(sd siteData, td tosData, endTime int, duration int) []siteOverall {
 	wg := &sync.WaitGroup{}
-	siteReport := make([]siteOverall, len(sd.Group.Sites))
-	startTime := endTime - duration
-	// Check at each site
-	for i, site := range sd.Group.Sites {
+	for w := 0; w < runtime.NumCPU(); w++ {
 		wg.Add(1)
-		go siteVehicle(wg, &siteReport[i], site, td, startTime, endTime, duration)
+		go func() {
+			defer wg.Done()
+			for {
+				// Stop early with what was found so far if cancelled
+				if ctx.Err() != nil {
+					return
+				}
+				vehicle, n, ok := next()
+				if !ok {
+					return
+				}
+				for i, s := range sd.Group.Sites {
+					if !valid[i] {
+						continue
+					}
+					lines, seconds := siteVisits(s, bounds[i], vehicle, startTime, endTime)
+					if len(lines) > 0 {
+						mu.Lock()
+						visits[i] = append(visits[i], vehicleVisits{n, lines, seconds})
+						mu.Unlock()
+					}
+				}
+			}
+		}()
 	}
-
 	wg.Wait()
 
+	siteReport := make([]siteOverall, len(sd.Group.Sites))
+	for i, s := range sd.Group.Sites {
+		// Only sites visited by at least one vehicle are filled in
+		if len(visits[i]) == 0 {
+			continue
+		}
+		sort.Slice(visits[i], func(a, b int) bool { return visits[i][a].seq < visits[i][b].seq })
+		report := siteOverall{siteName: s.Name, totalVehicles: len(visits[i])}
+		for _, v := range visits[i] {
+			report.lineEntry = append(report.lineEntry, v.lineEntry...)
+			report.totalVisits += len(v.lineEntry)
+			report.totalTime += v.time
+		}
+		siteReport[i] = report
+	}
 	return siteReport
 }
 
 // Prints the time on site information in a presentable way
-func printSite(siteReports []siteOverall, expanded bool) {
+func printSite(siteReports []siteOverall, expanded bool, loc *time.Location) {
 	fmt.Printf("\n\n")
 	// Iterate through sites
 	for _, siteReport := range siteReports {
@@ -500,8 +690,8 @@ func printSite(siteReports []siteOverall, expanded bool) {
 			// If user would like detailed trip information for the sites
 			if expanded {
 				for _, visit := range siteReport.lineEntry {
-					fmt.Printf("%-6s %-25s %-35s %-35s %12s %f %f \n", visit.vehicleName, visit.driverName, time.Unix(int64(visit.arrival/1000), 0),
-						time.Unix(int64(visit.departure/1000), 0), secToHours((visit.departure-visit.arrival)/1000), visit.lat, visit.long)
+					fmt.Printf("%-6s %-25s %-35s %-35s %12s %f %f \n", visit.vehicleName, visit.driverName, time.Unix(int64(visit.arrival/1000), 0).In(loc),
+						time.Unix(int64(visit.departure/1000), 0).In(loc), secToHours((visit.departure-visit.arrival)/1000), visit.lat, visit.long)
 				}
 				fmt.Printf("\n")
 			}
@@ -510,11 +700,23 @@ func printSite(siteReports []siteOverall, expanded bool) {
 	fmt.Printf("\n")
 }
 
+// Prints the footer listing the vehicles that could not be fetched, so a short report is not mistaken for a complete one
+func printFailed(failed []failedDevice) {
+	if len(failed) == 0 {
+		return
+	}
+	fmt.Printf("%d vehicle(s) could not be fetched and are not in the report above:\n", len(failed))
+	for _, f := range failed {
+		fmt.Printf("%-25s %-20d %s\n", f.name, f.id, f.err)
+	}
+	fmt.Printf("\n")
+}
+
 // Formats seconds into the time on site format of Xh Ym, or Xm Ys
 func secToHours(seconds int) string {
 	if seconds/3600 >= 1 {
 		hours := seconds / 3600
-		min := seconds % 60
+		min := seconds % 3600 / 60
 		return strconv.Itoa(hours) + "h " + strconv.Itoa(min) + "m"
 	} else if seconds < 0 {
 		return "negative"
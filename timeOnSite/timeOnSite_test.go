@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/thewhofan23/OwlCode/graphql"
+	"github.com/thewhofan23/OwlCode/mock"
 )
 
 // TestSecToHours Test the time formatting
@@ -22,6 +29,11 @@ func TestSecToHours(t *testing.T) {
 		t.Errorf("Zero second did not work, got: %s, want: %s", time2, expect2)
 	}
 
+	// Minutes past the hour, not the seconds
+	if got := secToHours(5582); got != "1h 33m" {
+		t.Errorf("Hours and minutes did not work, got: %s, want: %s", got, "1h 33m")
+	}
+
 	// Test negative handling
 	negative := -3600
 	time3 := secToHours(negative)
@@ -72,10 +84,13 @@ func TestGreatCircleDist(t *testing.T) {
 }
 
 func TestSiteQuery(t *testing.T) {
-	// Testing with my own org
-	groupID := "4656"
+	// Testing with the mock server's fixture org
+	groupID := 4656
 	expected1 := 4
-	result, err := siteQuery(groupID)
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path})
+	result, err := siteQuery(context.Background(), client, groupID)
 	if err != nil {
 		t.Errorf("Received an error: %s", err)
 	}
@@ -85,9 +100,10 @@ func TestSiteQuery(t *testing.T) {
 }
 
 func TestGetGPSBound(t *testing.T) {
+	var boundMulti float32 = 2
 	// Test when valid lat, long with a radius of 500m
 	var lat1, long1, r1 float32 = 37.733795, -122.446747, 500
-	result1, err1 := getGPSBound(lat1, long1, r1)
+	result1, err1 := getGPSBound(lat1, long1, r1, boundMulti)
 	expected1 := latLongRange{-122.45574, -122.43775, 37.7248, 37.74279}
 	if err1 != nil {
 		t.Errorf("Received an error: %s", err1)
@@ -98,7 +114,7 @@ func TestGetGPSBound(t *testing.T) {
 
 	// Test when radius is negative
 	var lat2, long2, r2 float32 = 37.733795, -122.446747, -500
-	result2, err2 := getGPSBound(lat2, long2, r2)
+	result2, err2 := getGPSBound(lat2, long2, r2, boundMulti)
 	expected2 := latLongRange{-122.45574, -122.43775, 37.7248, 37.74279}
 	if err2 != nil {
 		t.Errorf("Received an error: %s", err2)
@@ -109,7 +125,7 @@ func TestGetGPSBound(t *testing.T) {
 
 	// Test when radius is zero
 	var lat3, long3, r3 float32 = 37.733795, -122.446747, 0
-	result3, err3 := getGPSBound(lat3, long3, r3)
+	result3, err3 := getGPSBound(lat3, long3, r3, boundMulti)
 	expected3 := latLongRange{-122.446747, -122.446747, 37.733795, 37.733795}
 	if err3 != nil {
 		t.Errorf("Received an error: %s", err3)
@@ -120,7 +136,7 @@ func TestGetGPSBound(t *testing.T) {
 
 	// Test when bounds overlap over longitude range
 	var lat4, long4, r4 float32 = 37.733795, -179.999, 1000
-	result4, err4 := getGPSBound(lat4, long4, r4)
+	result4, err4 := getGPSBound(lat4, long4, r4, boundMulti)
 	expected4 := latLongRange{-180, 180, 37.71581, 37.75178}
 	if err4 != nil {
 		t.Errorf("Received an error: %s", err4)
@@ -132,9 +148,183 @@ func TestGetGPSBound(t *testing.T) {
 	// Test when bounds overlap over longitude range
 	var lat5, long5, r5 float32 = 89.9999, -122.446747, 1000
 	expected5 := latLongRange{}
-	result5, _ := getGPSBound(lat5, long5, r5)
+	result5, _ := getGPSBound(lat5, long5, r5, boundMulti)
 	if result5 != expected5 {
 		t.Errorf("Overlap at latitude unexpected value, got: %v, want: %v", result5, expected5)
 	}
 
 }
+
+// Feeds vehicles to checkSite the way tosQuery does
+func vehicleChan(vehicles ...devices) <-chan devices {
+	c := make(chan devices, len(vehicles))
+	for _, v := range vehicles {
+		c <- v
+	}
+	close(c)
+	return c
+}
+
+func TestCheckSiteCancelled(t *testing.T) {
+	// A vehicle parked at the only site for the whole window
+	sd := siteData{Group: sites{Sites: []site{{Latitude: 37.733795, Longitude: -122.446747, Name: "Yard", Radius: 500}}}}
+	truck := devices{Name: "Truck 1", VAR: vehicleActivityReport{TripEntries: []tripEntry{
+		{End: segment{Lat: 37.733795, Lng: -122.446747, Time: 1000}},
+	}}}
+
+	report := checkSite(context.Background(), sd, vehicleChan(truck), 5000, 5000, 2)
+	if report[0].totalVisits != 1 {
+		t.Errorf("Did not find the visit, got: %d visits, want: 1", report[0].totalVisits)
+	}
+
+	// Once cancelled the workers stop before checking any vehicle
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report = checkSite(ctx, sd, vehicleChan(truck), 5000, 5000, 2)
+	if report[0].totalVisits != 0 {
+		t.Errorf("Cancelled check kept working, got: %d visits, want: 0", report[0].totalVisits)
+	}
+}
+
+func TestCheckSiteOrder(t *testing.T) {
+	// Visits are listed in the order the vehicles arrived, whichever worker checked them
+	sd := siteData{Group: sites{Sites: []site{{Latitude: 37.733795, Longitude: -122.446747, Name: "Yard", Radius: 500}}}}
+	var vehicles []devices
+	for i := 0; i < 50; i++ {
+		vehicles = append(vehicles, devices{Name: strconv.Itoa(i), VAR: vehicleActivityReport{TripEntries: []tripEntry{
+			{End: segment{Lat: 37.733795, Lng: -122.446747, Time: 1000}},
+		}}})
+	}
+	report := checkSite(context.Background(), sd, vehicleChan(vehicles...), 5000, 5000, 2)
+	if report[0].totalVehicles != 50 || report[0].totalVisits != 50 {
+		t.Fatalf("Did not check every vehicle, got: %d vehicles and %d visits", report[0].totalVehicles, report[0].totalVisits)
+	}
+	for i, line := range report[0].lineEntry {
+		if line.vehicleName != strconv.Itoa(i) {
+			t.Fatalf("Visits out of order at %d, got vehicle: %s", i, line.vehicleName)
+		}
+	}
+}
+
+func TestTimeOnSiteReport(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path})
+	endTime, duration := 1541168971265, 3123000
+
+	sd, err := siteQuery(context.Background(), client, 4656)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	report, fetched, err := runReport(context.Background(), client, sd, 4656, endTime, duration, 8, false, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if fetched.vehicles != 2 {
+		t.Errorf("Did not stream every vehicle, got: %d, want: 2", fetched.vehicles)
+	}
+
+	// Truck 1 starts at HQ and waits 10 minutes at Oakland Yard between trips, Truck 2 parks at HQ then ends the window at Oakland
+	expected := map[string][2]int{"Owl HQ": {2, 2}, "Oakland Yard": {2, 2}, "San Jose Depot": {1, 1}}
+	for _, site := range report {
+		want, ok := expected[site.siteName]
+		if !ok {
+			continue
+		}
+		if site.totalVehicles != want[0] || site.totalVisits != want[1] {
+			t.Errorf("%s: got %d vehicles and %d visits, want: %d and %d", site.siteName, site.totalVehicles, site.totalVisits, want[0], want[1])
+		}
+		delete(expected, site.siteName)
+	}
+	if len(expected) != 0 {
+		t.Errorf("Sites missing from the report: %v", expected)
+	}
+}
+
+func TestTimeOnSiteReportChunked(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	endTime, duration := 1541168971265, 3123000
+	whole := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path})
+	sd, err := siteQuery(context.Background(), whole, 4656)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	want, _, err := runReport(context.Background(), whole, sd, 4656, endTime, duration, 8, false, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+
+	// Ten minute chunks split several trips and stays across boundaries. The group query is never split,
+	// so a window longer than a chunk is fetched per device with each batch in chunks, asked for or not.
+	chunked := graphql.NewClient(graphql.Options{
+		Endpoint: server.URL + mock.Path,
+		Limits:   graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}},
+		Chunking: graphql.Chunking{Size: 10 * time.Minute, Concurrency: 3},
+	})
+	got, fetched, err := runReport(context.Background(), chunked, sd, 4656, endTime, duration, 4, false, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	// The vehicle list, then one batch per chunk
+	if chunked.Stats().Requests != 1+6 || fetched.vehicles != 2 {
+		t.Errorf("Window was not split, got: %d requests and %d vehicles", chunked.Stats().Requests, fetched.vehicles)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chunked report differs from one query, got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestTimeOnSiteReportPerDevice(t *testing.T) {
+	server := mock.NewTestServer(mock.Options{})
+	defer server.Close()
+	endTime, duration := 1541168971265, 3123000
+	client := graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path, Limits: graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}}})
+	sd, err := siteQuery(context.Background(), client, 4656)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	want, _, err := runReport(context.Background(), client, sd, 4656, endTime, duration, 8, false, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+
+	// One query per vehicle gives the same report as one for the group, and both vehicles' queries
+	// go in one batched request after the vehicle list
+	client = graphql.NewClient(graphql.Options{Endpoint: server.URL + mock.Path, Limits: graphql.RateLimits{Default: graphql.RateLimit{RequestsPerSecond: -1}}})
+	got, fetched, err := runReport(context.Background(), client, sd, 4656, endTime, duration, 4, true, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if client.Stats().Requests != 2 {
+		t.Errorf("Vehicle queries were not batched, got: %d requests, want: 2", client.Stats().Requests)
+	}
+	if fetched.vehicles != 2 || len(fetched.failed) != 0 {
+		t.Errorf("Did not fetch every vehicle, got: %+v", fetched)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Per-device report differs from the group query, got: %+v, want: %+v", got, want)
+	}
+
+	// A broken vehicle is left out and listed, the rest of the report is still produced
+	broken := mock.NewTestServer(mock.Options{FailDevices: []int64{212014918137973}})
+	defer broken.Close()
+	client = graphql.NewClient(graphql.Options{Endpoint: broken.URL + mock.Path, Retry: graphql.RetryPolicy{MaxAttempts: 1}})
+	got, fetched, err = runReport(context.Background(), client, sd, 4656, endTime, duration, 4, true, 2)
+	if err != nil {
+		t.Fatalf("Received an error: %s", err)
+	}
+	if fetched.vehicles != 1 || len(fetched.failed) != 1 || fetched.failed[0].name != "Truck 2" {
+		t.Fatalf("Did not list the broken vehicle, got: %+v", fetched)
+	}
+	for _, site := range got {
+		for _, visit := range site.lineEntry {
+			if visit.vehicleName != "Truck 1" {
+				t.Errorf("Broken vehicle is in the report at %s", site.siteName)
+			}
+		}
+	}
+	if got[0].totalVisits != 1 {
+		t.Errorf("Did not keep the working vehicle, got: %d visits at %s, want: 1", got[0].totalVisits, got[0].siteName)
+	}
+}